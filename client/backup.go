@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/marsevilspirit/phobos/share"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// latencySamples is the number of recent latencies kept for each method.
+	latencySamples = 128
+	// minLatencySamples is the number of latencies needed to use BackupPercentile.
+	minLatencySamples = 16
+	// defaultBackupLatency is the backup delay if Option.BackupLatency is not set.
+	defaultBackupLatency = 10 * time.Millisecond
+)
+
+// latencyTracker keeps the latencies of the recent successful calls of a method.
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (t *latencyTracker) observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.samples) < latencySamples {
+		t.samples = append(t.samples, d)
+		return
+	}
+	t.samples[t.next] = d
+	t.next = (t.next + 1) % latencySamples
+}
+
+// percentile returns the p percentile of the latencies, false if there are not enough samples.
+func (t *latencyTracker) percentile(p float64) (time.Duration, bool) {
+	t.mu.Lock()
+	if len(t.samples) < minLatencySamples {
+		t.mu.Unlock()
+		return 0, false
+	}
+	samples := slices.Clone(t.samples)
+	t.mu.Unlock()
+
+	slices.Sort(samples)
+	i := int(p * float64(len(samples)))
+	if i >= len(samples) {
+		i = len(samples) - 1
+	}
+
+	return samples[i], true
+}
+
+// backupDelay returns how long Failbackup waits before sending the backup request.
+func (c *xClient) backupDelay(serviceMethod string) time.Duration {
+	if c.option.BackupPercentile > 0 {
+		if v, ok := c.latencies.Load(serviceMethod); ok {
+			if d, ok := v.(*latencyTracker).percentile(c.option.BackupPercentile); ok {
+				return d
+			}
+		}
+	}
+
+	if c.option.BackupLatency > 0 {
+		return c.option.BackupLatency
+	}
+	return defaultBackupLatency
+}
+
+func (c *xClient) observeLatency(serviceMethod string, d time.Duration) {
+	v, ok := c.latencies.Load(serviceMethod)
+	if !ok {
+		v, _ = c.latencies.LoadOrStore(serviceMethod, &latencyTracker{})
+	}
+	v.(*latencyTracker).observe(d)
+}
+
+// selectBackup selects a server other than k for the backup request.
+func (c *xClient) selectBackup(ctx context.Context, k, serviceMethod string, args any) (string, RPCClient) {
+	skip := func(s string) bool {
+		return s == k || c.isBreakerOpen(s, serviceMethod)
+	}
+
+	c.mu.RLock()
+	backup := c.selectServerLocked(ctx, c.servicePath, serviceMethod, args, skip)
+	c.mu.RUnlock()
+	if backup == "" || skip(backup) {
+		return "", nil
+	}
+
+	client, err := c.getCachedClient(backup)
+	if err != nil {
+		return "", nil
+	}
+
+	return backup, client
+}
+
+type backupResult struct {
+	k       string
+	client  RPCClient
+	reply   any
+	resMeta map[string]string
+	latency time.Duration
+	err     error
+}
+
+// callBackup calls the server k, and sends a backup request to another server if
+// there is no reply within the backup delay. The first successful reply is used
+// and the other request is canceled. Backup requests are limited by the retry budget.
+func (c *xClient) callBackup(ctx context.Context, k string, client RPCClient, serviceMethod string, args, reply any) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c.retryBudget.call()
+
+	results := make(chan *backupResult, 2)
+	call := func(k string, client RPCClient) {
+		r := &backupResult{k: k, client: client}
+		callCtx := ctx
+		// 两个请求各自接收响应的元数据, 避免并发写同一个 map
+		if _, ok := ctx.Value(share.ResMetaDataKey).(map[string]string); ok {
+			r.resMeta = make(map[string]string)
+			callCtx = context.WithValue(ctx, share.ResMetaDataKey, r.resMeta)
+		}
+		if reply != nil {
+			r.reply = newReply(reply)
+		}
+		start := time.Now()
+		r.err = c.wrapCall(callCtx, k, client, serviceMethod, args, r.reply)
+		r.latency = time.Since(start)
+		results <- r
+	}
+
+	go call(k, client)
+	pending := 1
+
+	timer := time.NewTimer(c.backupDelay(serviceMethod))
+	defer timer.Stop()
+
+	backup := func() {
+		bk, bc := c.selectBackup(ctx, k, serviceMethod, args)
+		if bc != nil && c.retryBudget.retry() {
+			go call(bk, bc)
+			pending++
+		}
+	}
+
+	var err error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			backup()
+		case r := <-results:
+			pending--
+			if r.err != nil {
+				// 服务端返回的错误重试也一样
+				if _, ok := r.err.(ServiceError); ok {
+					return r.err
+				}
+				if isConnError(r.err) {
+					c.removeClient(r.k, r.client)
+				}
+				err = r.err
+				// 在发送备份请求之前就失败了, 立刻发送备份请求
+				if r.k == k && timer.Stop() {
+					backup()
+				}
+				continue
+			}
+
+			c.observeLatency(serviceMethod, r.latency)
+			if reply != nil {
+				setReply(reply, r.reply)
+			}
+			if resMeta, ok := ctx.Value(share.ResMetaDataKey).(map[string]string); ok {
+				for k, v := range r.resMeta {
+					resMeta[k] = v
+				}
+			}
+			return nil
+		}
+	}
+
+	return err
+}
+
+// newReply returns a new reply of the same type as reply.
+func newReply(reply any) any {
+	return reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+}
+
+// setReply sets reply to src, a reply returned by newReply. The protobuf
+// messages are merged, their internal state must not be copied.
+func setReply(reply, src any) {
+	if m, ok := reply.(proto.Message); ok {
+		proto.Reset(m)
+		proto.Merge(m, src.(proto.Message))
+		return
+	}
+
+	reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(src).Elem())
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/marsevilspirit/phobos/protocol"
+	"github.com/marsevilspirit/phobos/server"
+)
+
+func TestLatencyTracker(t *testing.T) {
+	tracker := &latencyTracker{}
+	for i := 1; i < minLatencySamples; i++ {
+		tracker.observe(time.Duration(i) * time.Millisecond)
+	}
+	if _, ok := tracker.percentile(0.9); ok {
+		t.Fatal("expect no percentile without enough samples")
+	}
+
+	for i := 0; i < 2*latencySamples; i++ {
+		tracker.observe(time.Duration(i%100+1) * time.Millisecond)
+	}
+	if len(tracker.samples) != latencySamples {
+		t.Fatalf("expect %d samples but got %d", latencySamples, len(tracker.samples))
+	}
+	if d, ok := tracker.percentile(1); !ok || d != 100*time.Millisecond {
+		t.Fatalf("expect max latency 100ms but got %v", d)
+	}
+}
+
+func TestXClient_Failbackup(t *testing.T) {
+	start := func(delay time.Duration, c int) *server.Server {
+		s := server.NewServer()
+		server.RegisterHandler(s, "Arith", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			return &Reply{C: c}, nil
+		}, "")
+		go s.Serve("tcp", "127.0.0.1:0")
+		return s
+	}
+	slow := start(2*time.Second, 1)
+	defer slow.Close()
+	fast := start(0, 2)
+	defer fast.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	d := NewMultipleServersDiscovery([]*KVPair{
+		{Key: "tcp@" + slow.Address().String()},
+		{Key: "tcp@" + fast.Address().String()},
+	})
+	opt := DefaultOption
+	opt.BackupLatency = 50 * time.Millisecond
+	xclient := NewXClient("Arith", Failbackup, RoundRobin, d, opt)
+	defer xclient.Close()
+
+	for i := 0; i < 4; i++ {
+		start := time.Now()
+		reply := &Reply{}
+		if err := xclient.Call(context.Background(), "Mul", &Args{A: 1, B: 2}, reply); err != nil {
+			t.Fatalf("failed to call: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("expect the backup request to reply first but took %v", elapsed)
+		}
+		if reply.C != 2 {
+			t.Fatalf("expect the reply of the fast server but got %d", reply.C)
+		}
+	}
+
+	// 备份请求受重试预算限制
+	opt.RetryBudget = &RetryBudget{Ratio: 0, MinRetriesPerSecond: 0}
+	limited := NewXClient("Arith", Failbackup, RoundRobin, d, opt)
+	defer limited.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		reply := &Reply{}
+		if err := limited.Call(ctx, "Mul", &Args{A: 1, B: 2}, reply); err != nil {
+			if reply.C == 2 {
+				t.Fatal("expect no backup request without retry budget")
+			}
+			return
+		}
+	}
+	t.Fatal("expect the call to the slow server to time out without backup request")
+}
+
+func TestXClient_BackupDelay(t *testing.T) {
+	c := &xClient{}
+	if d := c.backupDelay("Mul"); d != defaultBackupLatency {
+		t.Fatalf("expect the default backup delay %v but got %v", defaultBackupLatency, d)
+	}
+}
+
+func TestXClient_FailbackupSendRawNoServer(t *testing.T) {
+	xclient := NewXClient("Arith", Failbackup, RandomSelect, NewMultipleServersDiscovery(nil), DefaultOption)
+	defer xclient.Close()
+
+	r := protocol.NewMessage()
+	r.ServicePath = "Arith"
+	r.ServiceMethod = "Mul"
+	if _, _, err := xclient.SendRaw(context.Background(), r); err != ErrXClientNoServer {
+		t.Fatalf("expect ErrXClientNoServer but got %v", err)
+	}
+}
+
+func TestSetReply(t *testing.T) {
+	reply := &ProtoReply{C: 1}
+	src := newReply(reply).(*ProtoReply)
+	src.C = 200
+	setReply(reply, src)
+	if reply.C != 200 {
+		t.Fatalf("expect 200 but got %d", reply.C)
+	}
+	// 合并而不是共享内部状态
+	src.C = 300
+	if reply.C != 200 {
+		t.Fatalf("expect the reply not to alias the source, got %d", reply.C)
+	}
+
+	r := &Reply{C: 1}
+	setReply(r, &Reply{C: 200})
+	if r.C != 200 {
+		t.Fatalf("expect 200 but got %d", r.C)
+	}
+}
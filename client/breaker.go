@@ -0,0 +1,82 @@
+package client
+
+import (
+	"github.com/marsevilspirit/phobos/breaker"
+)
+
+type breakerID struct {
+	server        string
+	serviceMethod string
+}
+
+// breakerKey returns the key of the breaker of server, or of serviceMethod of server.
+func breakerKey(server, serviceMethod string) string {
+	if serviceMethod == "" {
+		return server
+	}
+
+	return server + "/" + serviceMethod
+}
+
+// getBreaker returns the breaker of server k for serviceMethod, or nil if the
+// breakers are not created by Option.BreakerFactory.
+func (c *xClient) getBreaker(k, serviceMethod string) Breaker {
+	if c.option.Breaker != nil || c.option.BreakerFactory == nil {
+		return nil
+	}
+	if !c.option.BreakerPerMethod {
+		serviceMethod = ""
+	}
+
+	id := breakerID{server: k, serviceMethod: serviceMethod}
+
+	c.breakersMu.Lock()
+	defer c.breakersMu.Unlock()
+
+	b := c.breakers[id]
+	if b == nil {
+		b = c.option.BreakerFactory(k, serviceMethod)
+		if c.breakers == nil {
+			c.breakers = make(map[breakerID]Breaker)
+		}
+		c.breakers[id] = b
+	}
+
+	return b
+}
+
+// isBreakerOpen reports whether the breaker of server k for serviceMethod is open.
+// Breakers that don't report their state are never considered open.
+func (c *xClient) isBreakerOpen(k, serviceMethod string) bool {
+	b, ok := c.getBreaker(k, serviceMethod).(interface{ State() breaker.State })
+	return ok && b.State() == breaker.Open
+}
+
+// removeBreakers removes the breakers of the servers that are not in servers.
+func (c *xClient) removeBreakers(servers map[string]string) {
+	c.breakersMu.Lock()
+	defer c.breakersMu.Unlock()
+
+	for id := range c.breakers {
+		if _, ok := servers[id.server]; !ok {
+			delete(c.breakers, id)
+		}
+	}
+}
+
+// BreakerStates returns the states of the breakers created by
+// Option.BreakerFactory. The keys are the servers, or server/serviceMethod
+// if Option.BreakerPerMethod is set.
+func (c *xClient) BreakerStates() map[string]breaker.State {
+	c.breakersMu.Lock()
+	defer c.breakersMu.Unlock()
+
+	states := make(map[string]breaker.State, len(c.breakers))
+	for id, b := range c.breakers {
+		if sb, ok := b.(interface{ State() breaker.State }); ok {
+			states[breakerKey(id.server, id.serviceMethod)] = sb.State()
+		}
+	}
+
+	return states
+}
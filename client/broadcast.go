@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	ex "github.com/marsevilspirit/phobos/errors"
+	"github.com/marsevilspirit/phobos/share"
+)
+
+// ErrQuorumNotReached is returned by BroadcastAll and ForkAll if fewer servers
+// than the quorum replied successfully.
+var ErrQuorumNotReached = errors.New("not enough servers replied successfully")
+
+// broadcastTimeout is the timeout of Broadcast, Fork, BroadcastAll and ForkAll
+// if the context has no deadline.
+const broadcastTimeout = time.Minute
+
+// CallResult is the result of the call to a server of BroadcastAll and ForkAll.
+type CallResult struct {
+	// Server is the network@address of the server.
+	Server string
+	// Reply is the reply of the server, of the same type as the reply of the call.
+	Reply any
+	Error error
+	// Latency is how long the call took.
+	Latency time.Duration
+}
+
+// BroadcastAll calls all the servers and waits for all of them, or until the
+// deadline of ctx. It succeeds if at least quorum servers reply successfully,
+// quorum <= 0 means all the servers. reply is set to the first successful reply.
+func (c *xClient) BroadcastAll(ctx context.Context, serviceMethod string, args, reply any, quorum int) ([]*CallResult, error) {
+	return c.interceptAll(ctx, serviceMethod, args, reply, quorum, false)
+}
+
+// ForkAll calls all the servers and returns once quorum servers reply successfully,
+// quorum <= 0 means 1, the other calls are canceled. It also returns once the
+// quorum can not be reached any more. reply is set to the first successful reply.
+func (c *xClient) ForkAll(ctx context.Context, serviceMethod string, args, reply any, quorum int) ([]*CallResult, error) {
+	return c.interceptAll(ctx, serviceMethod, args, reply, quorum, true)
+}
+
+// interceptAll calls callAll through Option.Interceptors.
+func (c *xClient) interceptAll(ctx context.Context, serviceMethod string, args, reply any, quorum int, fork bool) ([]*CallResult, error) {
+	var results []*CallResult
+	err := c.intercept(ctx, serviceMethod, args, reply, func(ctx context.Context, serviceMethod string, args, reply any) error {
+		var err error
+		results, err = c.callAll(ctx, serviceMethod, args, reply, quorum, fork)
+		return err
+	})
+
+	return results, err
+}
+
+// callAll calls all the routed servers concurrently. The results are in the
+// order the calls return, the calls canceled by fork come last.
+func (c *xClient) callAll(ctx context.Context, serviceMethod string, args, reply any, quorum int, fork bool) ([]*CallResult, error) {
+	if c.isShutdown {
+		return nil, ErrXClientShutdown
+	}
+
+	if c.auth != "" {
+		metadata := ctx.Value(share.ReqMetaDataKey)
+		if metadata == nil {
+			return nil, errors.New("must set ReqMetaDataKey in context")
+		}
+		m := metadata.(map[string]string)
+		m[share.AuthKey] = c.auth
+	}
+
+	c.mu.RLock()
+	routed := c.routeLocked(ctx, serviceMethod, c.availableServersLocked())
+	servers := make([]string, 0, len(routed))
+	for k := range routed {
+		servers = append(servers, k)
+	}
+	c.mu.RUnlock()
+
+	if len(servers) == 0 {
+		return nil, ErrXClientNoServer
+	}
+
+	if quorum <= 0 {
+		quorum = len(servers)
+		if fork {
+			quorum = 1
+		}
+	}
+
+	var cancel context.CancelFunc
+	if _, ok := ctx.Deadline(); ok {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, broadcastTimeout)
+	}
+	defer cancel()
+
+	done := make(chan *CallResult, len(servers))
+	for _, k := range servers {
+		go func(k string) {
+			r := &CallResult{Server: k}
+			if reply != nil {
+				r.Reply = newReply(reply)
+			}
+
+			start := time.Now()
+			// getCachedClient 可能需要写锁, 不能在持有读锁时调用
+			client, _ := c.getCachedClient(k)
+			r.Error = c.wrapCall(ctx, k, client, serviceMethod, args, r.Reply)
+			r.Latency = time.Since(start)
+			if r.Error != nil && client != nil && isConnError(r.Error) {
+				c.removeClient(k, client)
+			}
+
+			done <- r
+		}(k)
+	}
+
+	results := make([]*CallResult, 0, len(servers))
+	var errs []error
+	var first *CallResult
+	succeeded := 0
+	for range servers {
+		r := <-done
+		results = append(results, r)
+		if r.Error == nil {
+			succeeded++
+			if first == nil {
+				first = r
+			}
+		} else {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Server, r.Error))
+		}
+
+		// 已经达到或者不可能达到 quorum 时取消其它调用
+		if fork && (succeeded >= quorum || len(errs) > len(servers)-quorum) {
+			cancel()
+		}
+	}
+
+	if first != nil && reply != nil {
+		setReply(reply, first.Reply)
+	}
+
+	if succeeded < quorum {
+		return results, fmt.Errorf("%w: %d of %d servers, %d needed: %w",
+			ErrQuorumNotReached, succeeded, len(servers), quorum, ex.NewMultiError(errs))
+	}
+
+	return results, nil
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/marsevilspirit/phobos/server"
+)
+
+func TestXClient_BroadcastAllAndForkAll(t *testing.T) {
+	handlers := []func(ctx context.Context, args *Args) (*Reply, error){
+		func(ctx context.Context, args *Args) (*Reply, error) {
+			return &Reply{C: 1}, nil
+		},
+		func(ctx context.Context, args *Args) (*Reply, error) {
+			time.Sleep(300 * time.Millisecond)
+			return &Reply{C: 2}, nil
+		},
+		func(ctx context.Context, args *Args) (*Reply, error) {
+			return nil, errors.New("bad backend")
+		},
+	}
+	var pairs []*KVPair
+	for _, h := range handlers {
+		s := server.NewServer()
+		server.RegisterHandler(s, "Arith", "Mul", h, "")
+		go s.Serve("tcp", "127.0.0.1:0")
+		defer s.Close()
+		time.Sleep(200 * time.Millisecond)
+		pairs = append(pairs, &KVPair{Key: "tcp@" + s.Address().String()})
+	}
+
+	opt := DefaultOption
+	opt.BreakerFactory = nil
+	xclient := NewXClient("Arith", Failfast, RandomSelect, NewMultipleServersDiscovery(pairs), opt)
+	defer xclient.Close()
+
+	reply := &Reply{}
+	results, err := xclient.BroadcastAll(context.Background(), "Mul", &Args{}, reply, 0)
+	if !errors.Is(err, ErrQuorumNotReached) {
+		t.Fatalf("expect ErrQuorumNotReached but got %v", err)
+	}
+	if len(results) != 3 || reply.C != 1 {
+		t.Fatalf("expect 3 results and the first reply but got %d results and %d", len(results), reply.C)
+	}
+	for _, r := range results {
+		switch r.Server {
+		case pairs[0].Key:
+			if r.Error != nil || r.Reply.(*Reply).C != 1 {
+				t.Fatalf("unexpected result of the fast server: %+v", r)
+			}
+		case pairs[1].Key:
+			if r.Error != nil || r.Reply.(*Reply).C != 2 || r.Latency < 300*time.Millisecond {
+				t.Fatalf("unexpected result of the slow server: %+v", r)
+			}
+		case pairs[2].Key:
+			if r.Error == nil {
+				t.Fatal("expect the error of the failing server")
+			}
+		}
+	}
+
+	if _, err := xclient.BroadcastAll(context.Background(), "Mul", &Args{}, &Reply{}, 2); err != nil {
+		t.Fatalf("expect quorum of 2 to succeed but got %v", err)
+	}
+
+	start := time.Now()
+	reply = &Reply{}
+	if _, err := xclient.ForkAll(context.Background(), "Mul", &Args{}, reply, 1); err != nil || reply.C != 1 {
+		t.Fatalf("expect the fast reply but got %d, %v", reply.C, err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("expect ForkAll not to wait for the slow server but took %v", elapsed)
+	}
+
+	if _, err := xclient.ForkAll(context.Background(), "Mul", &Args{}, &Reply{}, 2); err != nil {
+		t.Fatalf("expect quorum of 2 to succeed but got %v", err)
+	}
+
+	// 使用 ctx 的超时时间
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	results, err = xclient.BroadcastAll(ctx, "Mul", &Args{}, &Reply{}, 1)
+	if err != nil || len(results) != 3 {
+		t.Fatalf("expect quorum of 1 to succeed but got %d results and %v", len(results), err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Fatalf("expect BroadcastAll to return at the deadline but took %v", elapsed)
+	}
+	for _, r := range results {
+		if r.Server == pairs[1].Key && !errors.Is(r.Error, context.DeadlineExceeded) {
+			t.Fatalf("expect the slow server to time out but got %v", r.Error)
+		}
+	}
+
+	if err := xclient.Broadcast(context.Background(), "Mul", &Args{}, &Reply{}); err == nil {
+		t.Fatal("expect Broadcast to fail")
+	}
+	if err := xclient.Fork(context.Background(), "Mul", &Args{}, &Reply{}); err != nil {
+		t.Fatalf("failed to fork: %v", err)
+	}
+}
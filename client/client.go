@@ -43,9 +43,10 @@ func (e ServiceError) Error() string {
 
 var DefaultOption = Option{
 	Retries:        3,
+	RetryPolicy:    DefaultRetryPolicy,
 	RPCPath:        share.DefaultRPCPath,
 	ConnectTimeout: 10 * time.Second,
-	Breaker:        defaultBreaker,
+	BreakerFactory: defaultBreakerFactory,
 	SerializeType:  protocol.MsgPack,
 	CompressType:   protocol.None,
 }
@@ -54,14 +55,26 @@ type Breaker interface {
 	Execute(func() (any, error)) (any, error)
 }
 
+// BreakerFactory creates the breaker of server, the network@address of a server.
+// serviceMethod is empty unless Option.BreakerPerMethod is set.
+type BreakerFactory func(server, serviceMethod string) Breaker
+
 var defaultBreakerSettings = breaker.Settings{
 	Name:        "defaultBreakerSettings",
 	MaxRequests: 5,
 	Interval:    10 * time.Second,
 	Timeout:     30 * time.Second,
+	// 被取消的请求, 比如备份请求中较慢的那个, 以及被服务端限流的请求, 不算失败
+	IsSuccessful: func(err error) bool {
+		return err == nil || errors.Is(err, context.Canceled) || IsRateLimited(err)
+	},
 }
 
-var defaultBreaker Breaker = breaker.NewBreaker(defaultBreakerSettings)
+func defaultBreakerFactory(server, serviceMethod string) Breaker {
+	st := defaultBreakerSettings
+	st.Name = breakerKey(server, serviceMethod)
+	return breaker.NewBreaker(st)
+}
 
 var (
 	ErrShutdown        = errors.New("connection is shutdown")
@@ -109,6 +122,9 @@ type RPCClient interface {
 	RegisterServerMessageChan(ch chan<- *protocol.Message)
 	UnregisterServerMessageChan()
 
+	NewStream(ctx context.Context, servicePath, serviceMethod string) (Stream, error)
+	CheckHealth(ctx context.Context, servicePath string) (share.ServingStatus, error)
+
 	IsClosing() bool
 	IsShutdown() bool
 }
@@ -123,9 +139,16 @@ type Client struct {
 	mu       sync.Mutex
 	seq      uint64
 	pending  map[uint64]*Call
+	streams  map[uint64]*clientStream
 	closing  bool // closing 是用户主动关闭的
 	shutdown bool // shutdown 是error发生时调用的
 
+	network      string
+	address      string
+	state        ConnState
+	stateChanged chan struct{} // 状态变化时关闭
+	closed       chan struct{} // 关闭时停止重连
+
 	Plugins PluginContainer
 
 	ServerMessageChan chan<- *protocol.Message
@@ -149,8 +172,8 @@ func NewClient(options Option) *Client {
 		client.option.WriteTimeout = DefaultOption.WriteTimeout
 	}
 
-	if client.option.Breaker == nil {
-		client.option.Breaker = DefaultOption.Breaker
+	if client.option.Breaker == nil && client.option.BreakerFactory == nil {
+		client.option.BreakerFactory = DefaultOption.BreakerFactory
 	}
 
 	if client.option.SerializeType == 0 {
@@ -169,7 +192,14 @@ func NewClient(options Option) *Client {
 }
 
 type Option struct {
+	// Retries is the max number of attempts of a call in Failtry and Failover mode.
 	Retries int
+	// RetryPolicy decides which failed calls are retried and how long to wait
+	// between the attempts. If it is nil, all the calls that are not failed by
+	// the server are retried immediately.
+	RetryPolicy RetryPolicy
+	// RetryBudget limits the retries of an XClient, no limit if it is nil.
+	RetryBudget *RetryBudget
 
 	TLSConfig *tls.Config
 	RPCPath   string
@@ -178,7 +208,14 @@ type Option struct {
 	ReadTimeout    time.Duration
 	WriteTimeout   time.Duration
 
+	// Breaker is shared by all the calls of the Client, and by all the servers of an XClient.
 	Breaker Breaker
+	// BreakerFactory creates a breaker for each server if Breaker is nil.
+	// A Client creates its breaker when it connects, and an XClient skips
+	// the servers whose breaker is open when it selects a server.
+	BreakerFactory BreakerFactory
+	// BreakerPerMethod makes XClient create a breaker for each method of each server.
+	BreakerPerMethod bool
 
 	SerializeType protocol.SerializeType
 	CompressType  protocol.CompressType
@@ -186,6 +223,49 @@ type Option struct {
 	Heartbeat bool
 
 	HeartbeatInterval time.Duration
+
+	// ConnectionsPerServer is the max number of connections of an XClient to
+	// each server, 1 by default. Connections are created only when all the
+	// existing ones have pending calls.
+	ConnectionsPerServer int
+	// ConnPoolMode decides which connection to a server is used by a call.
+	ConnPoolMode ConnPoolMode
+
+	// BackupLatency is the delay before Failbackup sends a backup request, 10ms by default.
+	BackupLatency time.Duration
+	// BackupPercentile makes Failbackup send a backup request after the latency
+	// percentile of the recent calls of the method, such as 0.95. BackupLatency is
+	// used until there are enough calls.
+	BackupPercentile float64
+
+	// OutlierDetection ejects the servers of XClient failing too often, nil disables it.
+	OutlierDetection *OutlierDetection
+
+	// Reconnect makes the client reconnect in background with exponential
+	// backoff when it fails to connect or loses the connection. The calls fail
+	// with ErrClientNotReady until it reconnects, unless they are made with
+	// WithWaitForReady.
+	Reconnect bool
+	// ReconnectBaseDelay is the delay before the first reconnection, 1s by default.
+	ReconnectBaseDelay time.Duration
+	// ReconnectMaxDelay is the upper bound of the delay, 2m by default.
+	ReconnectMaxDelay time.Duration
+	// ConnStateHandler is called when the state of the connection changes.
+	ConnStateHandler ConnStateHandler
+
+	// Interceptors wrap each call of XClient, including its retries: Call, Go,
+	// SendRaw, Broadcast, Fork, BroadcastAll and ForkAll. The first one is the
+	// outermost. For SendRaw, args is the *protocol.Message and reply is nil.
+	Interceptors []Interceptor
+	// AttemptInterceptors wrap each attempt to call a server, such as each
+	// retry of Failtry and each server of Broadcast.
+	AttemptInterceptors []Interceptor
+
+	// HealthCheckInterval enables the active health checks of XClient if it is
+	// positive. Servers failing the checks are not selected until they recover.
+	HealthCheckInterval time.Duration
+	// HealthCheckTimeout is the timeout of a check, HealthCheckInterval by default.
+	HealthCheckTimeout time.Duration
 }
 
 var _ io.Closer = (*Client)(nil)
@@ -207,8 +287,17 @@ func (client *Client) Close() error {
 	}
 
 	client.closing = true
+	if client.closed != nil {
+		close(client.closed)
+	}
+	conn := client.Conn
 	client.mu.Unlock()
-	return client.Conn.Close()
+
+	client.setState(Shutdown)
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
 }
 
 func (client *Client) Call(ctx context.Context, servicePath, serviceMethod string, args, reply any) error {
@@ -236,6 +325,7 @@ func (client *Client) call(ctx context.Context, servicePath, serviceMethod strin
 		if call != nil {
 			call.Error = ctx.Err()
 			call.done()
+			client.sendCancel(*seq)
 		}
 
 		return ctx.Err()
@@ -269,14 +359,22 @@ func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[str
 
 	seq := r.Seq()
 	client.mu.Lock()
+	if err := client.readyErrLocked(ctx); err != nil {
+		client.mu.Unlock()
+		return nil, nil, err
+	}
 	if client.pending == nil {
 		client.pending = make(map[uint64]*Call)
 	}
 	client.pending[seq] = call
 	client.mu.Unlock()
 
-	data := r.Encode()
-	_, err := client.Conn.Write(data)
+	// 不修改调用方的元数据
+	req := *r
+	req.Metadata = withTimeoutMetadata(ctx, r.Metadata)
+
+	data := req.Encode()
+	_, err := client.getConn().Write(data)
 	if err != nil {
 		client.mu.Lock()
 		call = client.pending[seq]
@@ -309,6 +407,7 @@ func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[str
 		if call != nil {
 			call.Error = ctx.Err()
 			call.done()
+			client.sendCancel(seq)
 		}
 
 		return nil, nil, ctx.Err()
@@ -323,6 +422,58 @@ func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[str
 	return m, payload, err
 }
 
+// sendCancel tells the server that the call with seq has been abandoned,
+// so the server can cancel the context of its handler.
+func (client *Client) sendCancel(seq uint64) {
+	client.mu.Lock()
+	if client.shutdown || client.closing {
+		client.mu.Unlock()
+		return
+	}
+	client.mu.Unlock()
+
+	req := protocol.GetPoolMsg()
+	req.SetMessageType(protocol.Request)
+	req.SetCancel(true)
+	req.SetOneway(true)
+	req.SetSeq(seq)
+	data := req.Encode()
+	protocol.FreeMsg(req)
+
+	if _, err := client.getConn().Write(data); err != nil {
+		log.Warnf("phobos: failed to send cancellation of request %d: %v", seq, err)
+	}
+}
+
+// withTimeoutMetadata returns a copy of meta that carries the remaining time
+// budget of ctx to the server, or meta itself if ctx has no deadline.
+func withTimeoutMetadata(ctx context.Context, meta map[string]string) map[string]string {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return meta
+	}
+
+	m := make(map[string]string, len(meta)+1)
+	for k, v := range meta {
+		m[k] = v
+	}
+	m[share.TimeoutKey] = encodeTimeout(deadline)
+	return m
+}
+
+// encodeTimeout encodes the time left until deadline in milliseconds, rounded
+// up so the server doesn't see a deadline in the future as expired.
+func encodeTimeout(deadline time.Time) string {
+	return strconv.FormatInt(timeoutMillis(time.Until(deadline)), 10)
+}
+
+func timeoutMillis(d time.Duration) int64 {
+	if d <= 0 {
+		return int64(d / time.Millisecond)
+	}
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
 func convertRes2Raw(res *protocol.Message) (map[string]string, []byte, error) {
 	m := make(map[string]string)
 	m[GatewayVersion] = strconv.Itoa(int(res.Version()))
@@ -410,15 +561,14 @@ func (client *Client) Go(ctx context.Context, servicePath, serviceMethod string,
 		log.Panic("rpc: done channel is unbuffered")
 	}
 
-	call.Done = done
 	client.send(ctx, call)
 	return call
 }
 
 func (client *Client) send(ctx context.Context, call *Call) {
 	client.mu.Lock()
-	if client.shutdown || client.closing {
-		call.Error = ErrShutdown
+	if err := client.readyErrLocked(ctx); err != nil {
+		call.Error = err
 		client.mu.Unlock()
 		call.done()
 		return
@@ -457,6 +607,8 @@ func (client *Client) send(ctx context.Context, call *Call) {
 			req.Metadata = call.Metadata
 		}
 
+		req.Metadata = withTimeoutMetadata(ctx, req.Metadata)
+
 		req.ServicePath = call.ServicePath
 		req.ServiceMethod = call.ServiceMethod
 
@@ -482,7 +634,7 @@ func (client *Client) send(ctx context.Context, call *Call) {
 	}
 
 	data := req.Encode()
-	_, err := client.Conn.Write(data)
+	_, err := client.getConn().Write(data)
 	if err != nil {
 		client.mu.Lock()
 		call = client.pending[seq]
@@ -494,9 +646,10 @@ func (client *Client) send(ctx context.Context, call *Call) {
 		}
 	}
 
+	isOneway := req.IsOneway()
 	protocol.FreeMsg(req)
 
-	if req.IsOneway() {
+	if isOneway {
 		client.mu.Lock()
 		call = client.pending[seq]
 		delete(client.pending, seq)
@@ -520,6 +673,12 @@ func (client *Client) receive() {
 			break
 		}
 
+		if res.StreamFrameType() != protocol.StreamNone {
+			client.handleStreamFrame(res)
+			res.Reset()
+			continue
+		}
+
 		seq := res.Seq()
 		var call *Call
 		isServerMessage := (res.MessageType() == protocol.Request && !res.IsHeartbeat() && res.IsOneway())
@@ -579,8 +738,12 @@ func (client *Client) receive() {
 	}
 
 	client.mu.Lock()
-	client.shutdown = true
 	closing := client.closing
+	// 开启重连时连接断开不会关闭客户端
+	reconnect := client.option.Reconnect && !closing
+	if !reconnect {
+		client.shutdown = true
+	}
 	if err == io.EOF {
 		if closing {
 			err = ErrShutdown
@@ -589,15 +752,29 @@ func (client *Client) receive() {
 		}
 	}
 
-	for _, call := range client.pending {
+	for seq, call := range client.pending {
+		delete(client.pending, seq)
 		call.Error = err
 		call.done()
 	}
 
+	for seq, st := range client.streams {
+		delete(client.streams, seq)
+		st.closeRecv(err)
+		st.window.Close()
+	}
+
 	client.mu.Unlock()
 	if err != nil && err != io.EOF && !closing {
 		log.Error("phobos: client protocol error:", err)
 	}
+
+	if reconnect {
+		client.setState(TransientFailure)
+		go client.reconnect()
+	} else {
+		client.setState(Shutdown)
+	}
 }
 
 func (client *Client) handleServerRequest(msg *protocol.Message) {
@@ -621,13 +798,18 @@ func (client *Client) heartbeat() {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		if client.shutdown || client.closing {
+		state := client.State()
+		if state == Shutdown {
 			break
 		}
+		// 重连期间不发送心跳
+		if state != Ready {
+			continue
+		}
 
 		err := client.Call(context.Background(), "", "", nil, nil)
 		if err != nil {
-			log.Warnf("phobos: client heartbeat error: %v to %s", err, client.Conn.RemoteAddr().String())
+			log.Warnf("phobos: client heartbeat error: %v to %s", err, client.address)
 		}
 	}
 }
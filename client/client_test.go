@@ -99,3 +99,133 @@ func TestClient_IT(t *testing.T) {
 		t.Fatalf("expect 200 but got %d", pbReply.C)
 	}
 }
+
+type Sleeper struct {
+	done chan error
+}
+
+func (t *Sleeper) Sleep(ctx context.Context, args *Args, reply *Reply) error {
+	select {
+	case <-ctx.Done():
+		t.done <- ctx.Err()
+		return ctx.Err()
+	case <-time.After(5 * time.Second):
+		t.done <- nil
+	}
+	return nil
+}
+
+func TestClient_DeadlinePropagation(t *testing.T) {
+	sleeper := &Sleeper{done: make(chan error, 1)}
+
+	s := server.NewServer()
+	s.RegisterWithName("Sleeper", sleeper, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	c := NewClient(DefaultOption)
+	err := c.Connect("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = c.Call(ctx, "Sleeper", "Sleep", &Args{}, &Reply{})
+	if err == nil {
+		t.Fatal("expect an error but got nil")
+	}
+
+	select {
+	case err := <-sleeper.done:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expect handler context to expire but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler context was not canceled")
+	}
+}
+
+func TestClient_SendRawKeepsMetadata(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterWithName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	c := NewClient(DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	r := protocol.NewMessage()
+	r.SetMessageType(protocol.Request)
+	r.SetSerializeType(protocol.JSON)
+	r.SetSeq(1)
+	r.ServicePath = "Arith"
+	r.ServiceMethod = "Mul"
+	r.Payload = []byte(`{"A":10,"B":20}`)
+	r.Metadata = map[string]string{"k": "v"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, _, err := c.SendRaw(ctx, r); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	if len(r.Metadata) != 1 || r.Metadata["k"] != "v" {
+		t.Fatalf("expect the metadata of the caller not to be modified, got %v", r.Metadata)
+	}
+}
+
+func TestClient_Cancel(t *testing.T) {
+	sleeper := &Sleeper{done: make(chan error, 1)}
+
+	s := server.NewServer()
+	s.RegisterWithName("Sleeper", sleeper, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	c := NewClient(DefaultOption)
+	err := c.Connect("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	err = c.Call(ctx, "Sleeper", "Sleep", &Args{}, &Reply{})
+	if err != context.Canceled {
+		t.Fatalf("expect Canceled but got %v", err)
+	}
+
+	select {
+	case err := <-sleeper.done:
+		if err != context.Canceled {
+			t.Fatalf("expect handler context to be canceled but got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler context was not canceled")
+	}
+}
+
+func TestTimeoutMillis(t *testing.T) {
+	cases := map[time.Duration]int64{
+		100 * time.Microsecond:  1,
+		time.Millisecond:        1,
+		1500 * time.Microsecond: 2,
+		0:                       0,
+		-time.Millisecond:       -1,
+	}
+	for d, want := range cases {
+		if got := timeoutMillis(d); got != want {
+			t.Fatalf("expect %d for %v but got %d", want, d, got)
+		}
+	}
+}
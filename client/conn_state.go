@@ -0,0 +1,199 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/marsevilspirit/phobos/log"
+)
+
+// ErrClientNotReady is returned by the calls of a client which is reconnecting.
+var ErrClientNotReady = errors.New("connection is not ready")
+
+// ConnState is the state of the connection of a Client.
+type ConnState int
+
+const (
+	// Idle is the state of a client that has not connected yet.
+	Idle ConnState = iota
+	// Connecting means the client is dialing the server.
+	Connecting
+	// Ready means the connection is established.
+	Ready
+	// TransientFailure means the client failed to connect or lost the
+	// connection, it reconnects in background if Option.Reconnect is set.
+	TransientFailure
+	// Shutdown means the client is closed, or lost the connection without Option.Reconnect.
+	Shutdown
+)
+
+var connStateStrs = [...]string{
+	"Idle",
+	"Connecting",
+	"Ready",
+	"TransientFailure",
+	"Shutdown",
+}
+
+func (s ConnState) String() string {
+	if s < 0 || int(s) >= len(connStateStrs) {
+		return "ConnState(" + strconv.Itoa(int(s)) + ")"
+	}
+	return connStateStrs[s]
+}
+
+// ConnStateHandler is called when the state of the connection to server changes.
+type ConnStateHandler func(server string, from, to ConnState)
+
+// 重连的退避时间, 与 gRPC 的默认值相同
+const (
+	defaultReconnectBaseDelay = time.Second
+	defaultReconnectMaxDelay  = 2 * time.Minute
+)
+
+type waitForReadyKey struct{}
+
+// WithWaitForReady makes the calls with the returned context wait until the
+// connection is ready, instead of failing at once with ErrClientNotReady
+// while the client is reconnecting.
+func WithWaitForReady(ctx context.Context) context.Context {
+	return context.WithValue(ctx, waitForReadyKey{}, true)
+}
+
+func isWaitForReady(ctx context.Context) bool {
+	v, _ := ctx.Value(waitForReadyKey{}).(bool)
+	return v
+}
+
+// State returns the state of the connection.
+func (client *Client) State() ConnState {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.state
+}
+
+func (client *Client) setState(state ConnState) {
+	client.mu.Lock()
+	from := client.state
+	if from == state || from == Shutdown {
+		client.mu.Unlock()
+		return
+	}
+	client.state = state
+	if client.stateChanged != nil {
+		close(client.stateChanged)
+		client.stateChanged = nil
+	}
+	server := client.network + "@" + client.address
+	client.mu.Unlock()
+
+	if client.option.ConnStateHandler != nil {
+		client.option.ConnStateHandler(server, from, state)
+	}
+}
+
+// WaitForReady waits until the connection is ready, or ctx is done.
+// It returns ErrShutdown if the client is closed or will not reconnect.
+func (client *Client) WaitForReady(ctx context.Context) error {
+	for {
+		client.mu.Lock()
+		switch {
+		case client.state == Ready:
+			client.mu.Unlock()
+			return nil
+		case client.state == Shutdown || client.closing,
+			client.state == TransientFailure && !client.option.Reconnect:
+			client.mu.Unlock()
+			return ErrShutdown
+		}
+		if client.stateChanged == nil {
+			client.stateChanged = make(chan struct{})
+		}
+		ch := client.stateChanged
+		client.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+// readyErrLocked returns the error of a call made now, waiting for the
+// connection first if ctx is WithWaitForReady. client.mu must be held, and it
+// is held again when readyErrLocked returns.
+func (client *Client) readyErrLocked(ctx context.Context) error {
+	if client.shutdown || client.closing {
+		return ErrShutdown
+	}
+	if client.state != Connecting && client.state != TransientFailure {
+		return nil
+	}
+	if !isWaitForReady(ctx) {
+		return ErrClientNotReady
+	}
+
+	client.mu.Unlock()
+	err := client.WaitForReady(ctx)
+	client.mu.Lock()
+	if err == nil && (client.shutdown || client.closing) {
+		err = ErrShutdown
+	}
+
+	return err
+}
+
+// getConn returns the connection, which is replaced when the client reconnects.
+func (client *Client) getConn() net.Conn {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.Conn
+}
+
+// reconnect connects to the server again with exponential backoff until it
+// succeeds or the client is closed.
+func (client *Client) reconnect() {
+	backoff := &BackoffRetryPolicy{
+		BaseDelay:  client.option.ReconnectBaseDelay,
+		MaxDelay:   client.option.ReconnectMaxDelay,
+		Multiplier: 1.6,
+		Jitter:     0.2,
+	}
+	if backoff.BaseDelay <= 0 {
+		backoff.BaseDelay = defaultReconnectBaseDelay
+	}
+	if backoff.MaxDelay <= 0 {
+		backoff.MaxDelay = defaultReconnectMaxDelay
+	}
+
+	client.mu.Lock()
+	if client.closed == nil {
+		client.closed = make(chan struct{})
+	}
+	closed := client.closed
+	client.mu.Unlock()
+
+	for n := 1; ; n++ {
+		t := time.NewTimer(backoff.Backoff(n))
+		select {
+		case <-closed:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		err := client.connect()
+		if err == nil {
+			log.Infof("phobos: reconnected to %s@%s", client.network, client.address)
+			return
+		}
+		if err == ErrShutdown {
+			return
+		}
+		log.Warnf("phobos: failed to reconnect to %s@%s: %v", client.network, client.address, err)
+	}
+}
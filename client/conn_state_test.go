@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marsevilspirit/phobos/protocol"
+	"github.com/marsevilspirit/phobos/server"
+)
+
+type stateRecorder struct {
+	mu     sync.Mutex
+	states []ConnState
+}
+
+func (r *stateRecorder) handle(server string, from, to ConnState) {
+	r.mu.Lock()
+	r.states = append(r.states, to)
+	r.mu.Unlock()
+}
+
+func (r *stateRecorder) has(state ConnState) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func startArithServer(t *testing.T, addr string) *server.Server {
+	s := server.NewServer()
+	server.RegisterHandler(s, "Arith", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+		return &Reply{C: args.A * args.B}, nil
+	}, "")
+	go s.Serve("tcp", addr)
+	time.Sleep(200 * time.Millisecond)
+	if s.Address() == nil {
+		t.Fatalf("failed to serve on %s", addr)
+	}
+	return s
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	s := startArithServer(t, "127.0.0.1:0")
+	addr := s.Address().String()
+
+	recorder := &stateRecorder{}
+	opt := DefaultOption
+	opt.Reconnect = true
+	opt.ReconnectBaseDelay = 50 * time.Millisecond
+	opt.ReconnectMaxDelay = 100 * time.Millisecond
+	opt.ConnStateHandler = recorder.handle
+	client := NewClient(opt)
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+	if client.State() != Ready {
+		t.Fatalf("expect Ready but got %v", client.State())
+	}
+
+	// 等待服务器接受连接, 否则关闭服务器时不会关闭这个连接
+	time.Sleep(100 * time.Millisecond)
+	s.Close()
+	time.Sleep(20 * time.Millisecond)
+	err := client.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, &Reply{})
+	if err != ErrClientNotReady {
+		t.Fatalf("expect ErrClientNotReady but got %v", err)
+	}
+	if !recorder.has(TransientFailure) || client.IsShutdown() {
+		t.Fatal("expect the client to be reconnecting")
+	}
+
+	// 等待服务器重新启动
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(WithWaitForReady(context.Background()), 5*time.Second)
+		defer cancel()
+		reply := &Reply{}
+		err := client.Call(ctx, "Arith", "Mul", &Args{A: 2, B: 3}, reply)
+		if err == nil && reply.C != 6 {
+			t.Errorf("expect 6 but got %d", reply.C)
+		}
+		done <- err
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	s = startArithServer(t, addr)
+	defer s.Close()
+
+	if err := <-done; err != nil {
+		t.Fatalf("failed to call after reconnecting: %v", err)
+	}
+	if client.State() != Ready {
+		t.Fatalf("expect Ready but got %v", client.State())
+	}
+
+	client.Close()
+	if client.State() != Shutdown {
+		t.Fatalf("expect Shutdown but got %v", client.State())
+	}
+	if err := client.WaitForReady(context.Background()); err != ErrShutdown {
+		t.Fatalf("expect ErrShutdown but got %v", err)
+	}
+}
+
+func TestClient_ReconnectAfterConnectFails(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	opt := DefaultOption
+	opt.Reconnect = true
+	opt.ReconnectBaseDelay = 50 * time.Millisecond
+	opt.ReconnectMaxDelay = 100 * time.Millisecond
+	client := NewClient(opt)
+	if err := client.Connect("tcp", addr); err == nil {
+		t.Fatal("expect to fail to connect")
+	}
+	defer client.Close()
+	if client.State() != TransientFailure && client.State() != Connecting {
+		t.Fatalf("expect TransientFailure but got %v", client.State())
+	}
+
+	s := startArithServer(t, addr)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.WaitForReady(ctx); err != nil {
+		t.Fatalf("failed to wait for ready: %v", err)
+	}
+	if err := client.Call(context.Background(), "Arith", "Mul", &Args{A: 2, B: 3}, &Reply{}); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+}
+
+type heartbeatPlugin struct {
+	heartbeats atomic.Int32
+}
+
+func (p *heartbeatPlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if r != nil && r.IsHeartbeat() {
+		p.heartbeats.Add(1)
+	}
+	return nil
+}
+
+func TestClient_HeartbeatAfterConnectFails(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	opt := DefaultOption
+	opt.Reconnect = true
+	opt.ReconnectBaseDelay = 50 * time.Millisecond
+	opt.ReconnectMaxDelay = 100 * time.Millisecond
+	opt.Heartbeat = true
+	opt.HeartbeatInterval = 50 * time.Millisecond
+	client := NewClient(opt)
+	if err := client.Connect("tcp", addr); err == nil {
+		t.Fatal("expect to fail to connect")
+	}
+	defer client.Close()
+
+	p := &heartbeatPlugin{}
+	s := server.NewServer()
+	s.Plugins.Add(p)
+	go s.Serve("tcp", addr)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.WaitForReady(ctx); err != nil {
+		t.Fatalf("failed to wait for ready: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if p.heartbeats.Load() == 0 {
+		t.Fatal("expect heartbeats after the client reconnects")
+	}
+}
+
+func TestConnState_String(t *testing.T) {
+	if s := Ready.String(); s != "Ready" {
+		t.Fatalf("expect Ready but got %s", s)
+	}
+	if s := ConnState(42).String(); s != "ConnState(42)" {
+		t.Fatalf("expect ConnState(42) but got %s", s)
+	}
+}
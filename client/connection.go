@@ -13,7 +13,42 @@ import (
 	"github.com/marsevilspirit/phobos/share"
 )
 
+// Connect connects to the server at address. If Option.Reconnect is set, the
+// client keeps reconnecting in background with backoff after the connection
+// fails or is lost, even if Connect returns an error.
 func (c *Client) Connect(network, address string) error {
+	c.mu.Lock()
+	c.network, c.address = network, address
+	c.mu.Unlock()
+
+	err := c.connect()
+	if err != nil && (err == ErrShutdown || !c.option.Reconnect) {
+		return err
+	}
+
+	// 第一次连接失败时也启动心跳, 重连成功之后开始发送
+	if c.option.Heartbeat && c.option.HeartbeatInterval > 0 {
+		go c.heartbeat()
+	}
+	if err != nil {
+		go c.reconnect()
+	}
+
+	return err
+}
+
+// connect dials the server and starts receiving from the connection.
+func (c *Client) connect() error {
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return ErrShutdown
+	}
+	network, address := c.network, c.address
+	c.mu.Unlock()
+
+	c.setState(Connecting)
+
 	var conn net.Conn
 	var err error
 
@@ -26,26 +61,38 @@ func (c *Client) Connect(network, address string) error {
 		conn, err = newDirectConn(c, network, address)
 	}
 
-	if err == nil && conn != nil {
-		if c.option.ReadTimeout != 0 {
-			conn.SetReadDeadline(time.Now().Add(c.option.ReadTimeout))
-		}
-		if c.option.WriteTimeout != 0 {
-			conn.SetWriteDeadline(time.Now().Add(c.option.WriteTimeout))
-		}
-
-		c.Conn = conn
-		c.r = bufio.NewReaderSize(conn, ReaderBuffsize)
-		// c.w = bufio.NewWriterSize(conn, WriterBuffsize)
+	if err != nil {
+		c.setState(TransientFailure)
+		return err
+	}
 
-		go c.receive()
+	if c.option.ReadTimeout != 0 {
+		conn.SetReadDeadline(time.Now().Add(c.option.ReadTimeout))
+	}
+	if c.option.WriteTimeout != 0 {
+		conn.SetWriteDeadline(time.Now().Add(c.option.WriteTimeout))
+	}
 
-		if c.option.Heartbeat && c.option.HeartbeatInterval > 0 {
-			go c.heartbeat()
-		}
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		conn.Close()
+		return ErrShutdown
+	}
+	if c.option.Breaker == nil && c.option.BreakerFactory != nil {
+		c.option.Breaker = c.option.BreakerFactory(network+"@"+address, "")
 	}
 
-	return err
+	c.Conn = conn
+	c.r = bufio.NewReaderSize(conn, ReaderBuffsize)
+	// c.w = bufio.NewWriterSize(conn, WriterBuffsize)
+	c.mu.Unlock()
+
+	c.setState(Ready)
+
+	go c.receive()
+
+	return nil
 }
 
 func newDirectConn(c *Client, network, address string) (net.Conn, error) {
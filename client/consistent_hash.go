@@ -1,8 +1,15 @@
 package client
 
 import (
+	"crypto/md5"
+	"encoding/binary"
 	"fmt"
 	"hash/fnv"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/marsevilspirit/phobos/log"
 )
 
 func Hash(key uint64, buckets int32) int32 {
@@ -45,3 +52,77 @@ type HashServiceAndArgs func(len int, options ...any) int
 // ConsistentFunction define a hash function
 // Return service address, like "tcp@127.0.0.1:8970"
 type ConsistentAddrStrFunction func(options ...any) string
+
+const (
+	// ketamaReplicas is the number of virtual nodes of a server with weight 1.
+	ketamaReplicas = 160
+	// maxHashWeight limits the virtual nodes of a misconfigured weight.
+	maxHashWeight = 100
+)
+
+type ringNode struct {
+	hash   uint32
+	server string
+}
+
+// hashRing is a ketama consistent hash ring. The ring only depends on the
+// servers and their weights, so it is the same however the servers are ordered,
+// and only the keys of the added or removed servers move when the servers change.
+type hashRing struct {
+	nodes []ringNode
+}
+
+// newHashRing creates the ring of servers, a server has ketamaReplicas virtual
+// nodes for each weight in its metadata, such as "weight=2". The weight is at
+// most maxHashWeight.
+func newHashRing(servers map[string]string) *hashRing {
+	r := &hashRing{}
+	for server, metadata := range servers {
+		weight := 1
+		if v, err := url.ParseQuery(metadata); err == nil {
+			if w, err := strconv.Atoi(v.Get("weight")); err == nil && w > 0 {
+				weight = w
+			}
+		}
+		if weight > maxHashWeight {
+			log.Warnf("phobos: weight %d of server %s is too large, use %d", weight, server, maxHashWeight)
+			weight = maxHashWeight
+		}
+
+		// 每个 md5 摘要产生 4 个虚拟节点
+		for i := 0; i < ketamaReplicas*weight/4; i++ {
+			digest := md5.Sum([]byte(server + "-" + strconv.Itoa(i)))
+			for j := 0; j < 4; j++ {
+				r.nodes = append(r.nodes, ringNode{
+					hash:   binary.LittleEndian.Uint32(digest[j*4:]),
+					server: server,
+				})
+			}
+		}
+	}
+
+	sort.Slice(r.nodes, func(i, j int) bool {
+		if r.nodes[i].hash != r.nodes[j].hash {
+			return r.nodes[i].hash < r.nodes[j].hash
+		}
+		return r.nodes[i].server < r.nodes[j].server
+	})
+
+	return r
+}
+
+// get returns the server of key, the first virtual node clockwise from the hash of key.
+func (r *hashRing) get(key string) string {
+	if len(r.nodes) == 0 {
+		return ""
+	}
+
+	h := md5.Sum([]byte(key))
+	hash := binary.LittleEndian.Uint32(h[:4])
+	i := sort.Search(len(r.nodes), func(i int) bool { return r.nodes[i].hash >= hash })
+	if i == len(r.nodes) {
+		i = 0
+	}
+
+	return r.nodes[i].server
+}
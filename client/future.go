@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"reflect"
+)
+
+// Future is the pending result of a call made by XClient.Async.
+type Future interface {
+	// Done returns a channel that is closed when the call completes.
+	Done() <-chan struct{}
+	// Wait waits for the call to complete and returns its error.
+	// The reply of the call is set once Wait returns nil.
+	Wait() error
+	// Cancel cancels the call if it has not completed, Wait then returns context.Canceled.
+	Cancel()
+}
+
+type future struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	err    error
+}
+
+func (f *future) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *future) Wait() error {
+	<-f.done
+	return f.err
+}
+
+func (f *future) Cancel() {
+	f.cancel()
+}
+
+// Async calls serviceMethod asynchronously. Unlike Go, the call goes through
+// the same fail mode, retries, plugins and breakers as Call.
+func (c *xClient) Async(ctx context.Context, serviceMethod string, args, reply any) Future {
+	ctx, cancel := context.WithCancel(ctx)
+	f := &future{done: make(chan struct{}), cancel: cancel}
+
+	go func() {
+		defer cancel()
+		f.err = c.Call(ctx, serviceMethod, args, reply)
+		close(f.done)
+	}()
+
+	return f
+}
+
+// WaitAll waits for all the futures to complete, and returns the first error
+// of them in the order of futures.
+func WaitAll(futures ...Future) error {
+	var err error
+	for _, f := range futures {
+		if e := f.Wait(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// WaitAny waits for any of the futures to complete, and returns its index and
+// error. It returns -1 if there are no futures.
+func WaitAny(futures ...Future) (int, error) {
+	if len(futures) == 0 {
+		return -1, nil
+	}
+
+	cases := make([]reflect.SelectCase, len(futures))
+	for i, f := range futures {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(f.Done())}
+	}
+	i, _, _ := reflect.Select(cases)
+
+	return i, futures[i].Wait()
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/marsevilspirit/phobos/server"
+)
+
+func TestXClient_Async(t *testing.T) {
+	s := server.NewServer()
+	server.RegisterHandler(s, "Arith", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+		time.Sleep(time.Duration(args.A) * time.Millisecond)
+		return &Reply{C: args.A * args.B}, nil
+	}, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	xclient := NewXClient("Arith", Failtry, RandomSelect, NewP2PDiscovery("tcp@"+s.Address().String(), ""), DefaultOption)
+	defer xclient.Close()
+
+	reply := &Reply{}
+	f := xclient.Async(context.Background(), "Mul", &Args{A: 10, B: 20}, reply)
+	if err := f.Wait(); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C != 200 {
+		t.Fatalf("expect 200 but got %d", reply.C)
+	}
+
+	// 取消后立刻返回
+	f = xclient.Async(context.Background(), "Mul", &Args{A: 1000, B: 2}, &Reply{})
+	start := time.Now()
+	f.Cancel()
+	if err := f.Wait(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect context.Canceled but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expect canceled call to return at once but took %v", elapsed)
+	}
+
+	replies := []*Reply{{}, {}, {}}
+	futures := []Future{
+		xclient.Async(context.Background(), "Mul", &Args{A: 300, B: 1}, replies[0]),
+		xclient.Async(context.Background(), "Mul", &Args{A: 10, B: 2}, replies[1]),
+		xclient.Async(context.Background(), "Mul", &Args{A: 200, B: 3}, replies[2]),
+	}
+	if i, err := WaitAny(futures...); i != 1 || err != nil {
+		t.Fatalf("expect the second call to complete first but got %d, %v", i, err)
+	}
+	select {
+	case <-futures[0].Done():
+		t.Fatal("expect the first call not to be done")
+	default:
+	}
+
+	if err := WaitAll(futures...); err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if replies[0].C != 300 || replies[1].C != 20 || replies[2].C != 600 {
+		t.Fatalf("unexpected replies %d, %d, %d", replies[0].C, replies[1].C, replies[2].C)
+	}
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/marsevilspirit/phobos/log"
+	"github.com/marsevilspirit/phobos/protocol"
+	"github.com/marsevilspirit/phobos/share"
+)
+
+// CheckHealth asks the built-in health service of the server for the serving
+// status of servicePath, or of the whole server if servicePath is empty.
+// The check is always encoded in JSON, whatever the SerializeType of the client is.
+func (client *Client) CheckHealth(ctx context.Context, servicePath string) (share.ServingStatus, error) {
+	payload, err := json.Marshal(&share.HealthCheckArgs{ServicePath: servicePath})
+	if err != nil {
+		return share.ServingStatusUnknown, err
+	}
+
+	client.mu.Lock()
+	seq := client.seq
+	client.seq++
+	client.mu.Unlock()
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSeq(seq)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath = share.HealthServicePath
+	req.ServiceMethod = share.HealthCheck
+	if meta, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		req.Metadata = withTimeoutMetadata(ctx, meta)
+	}
+	req.Payload = payload
+
+	_, data, err := client.SendRaw(ctx, req)
+	if err != nil {
+		return share.ServingStatusUnknown, err
+	}
+
+	reply := &share.HealthCheckReply{}
+	if err := json.Unmarshal(data, reply); err != nil {
+		return share.ServingStatusUnknown, err
+	}
+
+	return reply.Status, nil
+}
+
+// startHealthCheck probes every server periodically if Option.HealthCheckInterval is set.
+// A server is excluded from selection once a probe fails or it is not SERVING,
+// and is selected again after a successful probe.
+func (c *xClient) startHealthCheck() {
+	if c.option.HealthCheckInterval <= 0 {
+		return
+	}
+
+	c.healthStop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(c.option.HealthCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.healthStop:
+				return
+			case <-ticker.C:
+				c.checkServers()
+			}
+		}
+	}()
+}
+
+func (c *xClient) stopHealthCheck() {
+	if c.healthStop != nil {
+		close(c.healthStop)
+	}
+}
+
+// checkServers probes all servers concurrently and updates the selector if
+// the health of any server has changed.
+func (c *xClient) checkServers() {
+	c.mu.RLock()
+	servers := make([]string, 0, len(c.servers))
+	for k := range c.servers {
+		servers = append(servers, k)
+	}
+	c.mu.RUnlock()
+
+	var mu sync.Mutex
+	healthy := make(map[string]bool, len(servers))
+	var wg sync.WaitGroup
+	for _, k := range servers {
+		wg.Add(1)
+		go func(k string) {
+			defer wg.Done()
+			ok := c.probe(k)
+			mu.Lock()
+			healthy[k] = ok
+			mu.Unlock()
+		}(k)
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := false
+	for k, ok := range healthy {
+		if _, exist := c.servers[k]; !exist {
+			// 在检查期间被服务发现移除了
+			continue
+		}
+		if ok == !c.unhealthy[k] {
+			continue
+		}
+
+		changed = true
+		if ok {
+			log.Infof("phobos: server %s of %s is healthy again", k, c.servicePath)
+			delete(c.unhealthy, k)
+		} else {
+			log.Warnf("phobos: server %s of %s is unhealthy, stop selecting it", k, c.servicePath)
+			if c.unhealthy == nil {
+				c.unhealthy = make(map[string]bool)
+			}
+			c.unhealthy[k] = true
+		}
+	}
+
+	if !changed {
+		return
+	}
+	if c.selector != nil {
+		c.selector.UpdateServer(c.availableServersLocked())
+	}
+	c.routeGen++
+}
+
+// probe reports whether the server k is serving the service of the client.
+func (c *xClient) probe(k string) bool {
+	timeout := c.option.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = c.option.HealthCheckInterval
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if c.auth != "" {
+		ctx = context.WithValue(ctx, share.ReqMetaDataKey, map[string]string{share.AuthKey: c.auth})
+	}
+
+	client, err := c.getCachedClient(k)
+	if err != nil {
+		return false
+	}
+
+	status, err := client.CheckHealth(ctx, c.servicePath)
+	if err != nil {
+		if _, ok := err.(ServiceError); !ok {
+			c.removeClient(k, client)
+		}
+		return false
+	}
+
+	return status == share.Serving
+}
+
+// availableServersLocked returns the servers that are not excluded by health
+// checks or ejected by outlier detection. c.mu must be held.
+func (c *xClient) availableServersLocked() map[string]string {
+	if len(c.unhealthy) == 0 && len(c.ejected) == 0 {
+		return c.servers
+	}
+
+	servers := make(map[string]string, len(c.servers))
+	for k, v := range c.servers {
+		if !c.unhealthy[k] && !c.ejected[k] {
+			servers[k] = v
+		}
+	}
+
+	return servers
+}
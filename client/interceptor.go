@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"maps"
+
+	"github.com/marsevilspirit/phobos/protocol"
+	"github.com/marsevilspirit/phobos/share"
+)
+
+// Invoker makes the call of serviceMethod and sets reply.
+type Invoker func(ctx context.Context, serviceMethod string, args, reply any) error
+
+// Interceptor wraps the calls of an XClient. It can call invoker with a new
+// context, such as one with more metadata by WithMetadata, replace args or
+// reply, or return without calling invoker, such as with a cached reply.
+type Interceptor func(ctx context.Context, serviceMethod string, args, reply any, invoker Invoker) error
+
+// chainInterceptors returns an Invoker calling invoker through interceptors,
+// the first interceptor is the outermost.
+func chainInterceptors(interceptors []Interceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, serviceMethod string, args, reply any) error {
+			return interceptor(ctx, serviceMethod, args, reply, next)
+		}
+	}
+
+	return invoker
+}
+
+// intercept calls invoker through Option.Interceptors.
+func (c *xClient) intercept(ctx context.Context, serviceMethod string, args, reply any, invoker Invoker) error {
+	if len(c.option.Interceptors) == 0 {
+		return invoker(ctx, serviceMethod, args, reply)
+	}
+
+	return chainInterceptors(c.option.Interceptors, invoker)(ctx, serviceMethod, args, reply)
+}
+
+// rawMessage returns the message of SendRaw in args, which the interceptors may
+// have replaced, or r if args is not a message.
+func rawMessage(args any, r *protocol.Message) *protocol.Message {
+	if m, ok := args.(*protocol.Message); ok && m != nil {
+		return m
+	}
+
+	return r
+}
+
+// WithMetadata returns a copy of ctx whose request metadata has key set to value.
+// The metadata of ctx is copied, not modified.
+func WithMetadata(ctx context.Context, key, value string) context.Context {
+	m, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	m = maps.Clone(m)
+	if m == nil {
+		m = make(map[string]string)
+	}
+	m[key] = value
+
+	return context.WithValue(ctx, share.ReqMetaDataKey, m)
+}
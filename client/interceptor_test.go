@@ -0,0 +1,220 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ex "github.com/marsevilspirit/phobos/errors"
+	"github.com/marsevilspirit/phobos/protocol"
+	"github.com/marsevilspirit/phobos/server"
+	"github.com/marsevilspirit/phobos/share"
+)
+
+func TestXClient_Interceptors(t *testing.T) {
+	var calls, failures atomic.Int32
+	s := server.NewServer()
+	server.RegisterHandler(s, "Arith", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+		calls.Add(1)
+		if failures.Add(-1) >= 0 {
+			return nil, ex.ErrServiceUnavailable
+		}
+		// 乘数来自拦截器注入的元数据
+		m := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+		factor, _ := strconv.Atoi(m["factor"])
+		return &Reply{C: args.A * args.B * factor}, nil
+	}, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	var order []string
+	var attempts atomic.Int32
+	cache := make(map[int]int)
+	opt := DefaultOption
+	opt.RetryPolicy = &BackoffRetryPolicy{
+		BaseDelay:      time.Millisecond,
+		RetryableCodes: []ex.ErrorCode{ex.ErrCodeServiceUnavailable},
+	}
+	opt.Interceptors = []Interceptor{
+		func(ctx context.Context, serviceMethod string, args, reply any, invoker Invoker) error {
+			order = append(order, "outer")
+			a := args.(*Args)
+			if c, ok := cache[a.A*a.B]; ok {
+				reply.(*Reply).C = c
+				return nil
+			}
+			err := invoker(ctx, serviceMethod, args, reply)
+			if err == nil {
+				cache[a.A*a.B] = reply.(*Reply).C
+			}
+			return err
+		},
+		func(ctx context.Context, serviceMethod string, args, reply any, invoker Invoker) error {
+			order = append(order, "inner")
+			return invoker(WithMetadata(ctx, "factor", "2"), serviceMethod, args, reply)
+		},
+	}
+	opt.AttemptInterceptors = []Interceptor{
+		func(ctx context.Context, serviceMethod string, args, reply any, invoker Invoker) error {
+			attempts.Add(1)
+			return invoker(ctx, serviceMethod, args, reply)
+		},
+	}
+	xclient := NewXClient("Arith", Failtry, RandomSelect, NewP2PDiscovery("tcp@"+s.Address().String(), ""), opt)
+	defer xclient.Close()
+
+	failures.Store(1)
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C != 400 {
+		t.Fatalf("expect 400 with the injected metadata but got %d", reply.C)
+	}
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Fatalf("expect interceptors to wrap the call once in order, got %v", order)
+	}
+	if attempts.Load() != 2 || calls.Load() != 2 {
+		t.Fatalf("expect 2 attempts but got %d attempts and %d calls", attempts.Load(), calls.Load())
+	}
+
+	// 缓存命中时不访问服务器
+	reply = &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 20, B: 10}, reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C != 400 || calls.Load() != 2 || attempts.Load() != 2 {
+		t.Fatalf("expect cached reply 400 but got %d after %d calls", reply.C, calls.Load())
+	}
+}
+
+type callPlugin struct {
+	preErr  error
+	postErr error
+	got     []error
+}
+
+func (p *callPlugin) DoPreCall(ctx context.Context, servicePath, serviceMethod string, args any) error {
+	return p.preErr
+}
+
+func (p *callPlugin) DoPostCall(ctx context.Context, servicePath, serviceMethod string, args any, reply any, err error) error {
+	p.got = append(p.got, err)
+	return p.postErr
+}
+
+func TestXClient_CallPluginErrors(t *testing.T) {
+	s := startArithServer(t, "127.0.0.1:0")
+	defer s.Close()
+
+	xclient := NewXClient("Arith", Failfast, RandomSelect, NewP2PDiscovery("tcp@"+s.Address().String(), ""), DefaultOption)
+	defer xclient.Close()
+
+	errDenied := errors.New("denied")
+	p := &callPlugin{preErr: errDenied}
+	xclient.SetPlugins(&pluginContainer{plugins: []Plugin{p}})
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, &Reply{}); err != errDenied {
+		t.Fatalf("expect the error of DoPreCall but got %v", err)
+	}
+
+	// 每个插件都拿到调用的错误, 插件返回的错误替换它
+	errPost := errors.New("post")
+	p1, p2 := &callPlugin{}, &callPlugin{postErr: errPost}
+	xclient.SetPlugins(&pluginContainer{plugins: []Plugin{p1, p2}})
+	if err := xclient.Call(context.Background(), "Div", &Args{A: 10, B: 20}, &Reply{}); err != errPost {
+		t.Fatalf("expect the error of DoPostCall but got %v", err)
+	}
+	if len(p1.got) != 1 || p1.got[0] == nil || len(p2.got) != 1 || p2.got[0] != p1.got[0] {
+		t.Fatalf("expect the plugins to get the error of the call, got %v and %v", p1.got, p2.got)
+	}
+
+	p2.postErr = nil
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, &Reply{}); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+}
+
+func TestXClient_InterceptorsEntryPoints(t *testing.T) {
+	s := startArithServer(t, "127.0.0.1:0")
+	defer s.Close()
+
+	var calls, attempts atomic.Int32
+	opt := DefaultOption
+	opt.Interceptors = []Interceptor{
+		func(ctx context.Context, serviceMethod string, args, reply any, invoker Invoker) error {
+			calls.Add(1)
+			return invoker(ctx, serviceMethod, args, reply)
+		},
+	}
+	opt.AttemptInterceptors = []Interceptor{
+		func(ctx context.Context, serviceMethod string, args, reply any, invoker Invoker) error {
+			attempts.Add(1)
+			return invoker(ctx, serviceMethod, args, reply)
+		},
+	}
+	xclient := NewXClient("Arith", Failfast, RandomSelect, NewP2PDiscovery("tcp@"+s.Address().String(), ""), opt)
+	defer xclient.Close()
+
+	args := &Args{A: 10, B: 20}
+	entries := map[string]func() error{
+		"Call": func() error {
+			return xclient.Call(context.Background(), "Mul", args, &Reply{})
+		},
+		"Go": func() error {
+			call, err := xclient.Go(context.Background(), "Mul", args, &Reply{}, nil)
+			if err != nil {
+				return err
+			}
+			return (<-call.Done).Error
+		},
+		"SendRaw": func() error {
+			r := protocol.NewMessage()
+			r.SetMessageType(protocol.Request)
+			r.SetSerializeType(protocol.JSON)
+			r.ServicePath = "Arith"
+			r.ServiceMethod = "Mul"
+			r.Payload = []byte(`{"A":10,"B":20}`)
+			_, _, err := xclient.SendRaw(context.Background(), r)
+			return err
+		},
+		"Broadcast": func() error {
+			return xclient.Broadcast(context.Background(), "Mul", args, &Reply{})
+		},
+		"Fork": func() error {
+			return xclient.Fork(context.Background(), "Mul", args, &Reply{})
+		},
+		"BroadcastAll": func() error {
+			_, err := xclient.BroadcastAll(context.Background(), "Mul", args, &Reply{}, 0)
+			return err
+		},
+		"ForkAll": func() error {
+			_, err := xclient.ForkAll(context.Background(), "Mul", args, &Reply{}, 0)
+			return err
+		},
+	}
+	for name, call := range entries {
+		calls.Store(0)
+		attempts.Store(0)
+		if err := call(); err != nil {
+			t.Fatalf("%s: failed to call: %v", name, err)
+		}
+		if calls.Load() != 1 || attempts.Load() != 1 {
+			t.Fatalf("%s: expect the interceptors to wrap it once, got %d calls and %d attempts", name, calls.Load(), attempts.Load())
+		}
+	}
+
+	// Go 也经过插件, DoPreCall 的错误使调用失败
+	errDenied := errors.New("denied")
+	xclient.SetPlugins(&pluginContainer{plugins: []Plugin{&callPlugin{preErr: errDenied}}})
+	call, err := xclient.Go(context.Background(), "Mul", args, &Reply{}, nil)
+	if err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if err := (<-call.Done).Error; err != errDenied {
+		t.Fatalf("expect the error of DoPreCall but got %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+package client
+
+import "context"
+
+// Invoke calls serviceMethod of the service of xc with req and returns the reply.
+// It is a type-safe form of XClient.Call, and is usually paired with
+// server.RegisterHandler on the server side.
+func Invoke[Req, Resp any](ctx context.Context, xc XClient, serviceMethod string, req *Req) (*Resp, error) {
+	resp := new(Resp)
+	if err := xc.Call(ctx, serviceMethod, req, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
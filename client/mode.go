@@ -10,6 +10,9 @@ const (
 	Failfast
 	// Failtry 模式表示在失败时对同一个候选者重新尝试3次
 	Failtry
+	// Failbackup 模式表示在一定时间内没有收到响应时, 向另一个候选者发送备份请求,
+	// 使用先返回的响应并取消另一个请求
+	Failbackup
 )
 
 // SelectMode 定义了选择处理模式
@@ -30,6 +33,10 @@ const (
 	Closest
 	// SelectByUser 模式表示由用户自定义选择候选者
 	SelectByUser
+	// LeastActive 模式表示选择正在处理的请求最少的候选者
+	LeastActive
+	// P2CEWMA 模式表示随机选择两个候选者, 从中选择延迟的指数加权移动平均乘以正在处理的请求数较小的一个
+	P2CEWMA
 )
 
 // `[...]string` 的声明方式表明数组的长度是根据初始化时的元素个数自动推断的
@@ -40,6 +47,9 @@ var selectModeStrs = [...]string{
 	"WeightedICMP",
 	"ConsistentHash",
 	"Closest",
+	"SelectByUser",
+	"LeastActive",
+	"P2CEWMA",
 }
 
 func (s SelectMode) String() string {
@@ -0,0 +1,243 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/marsevilspirit/phobos/log"
+)
+
+// OutlierDetection ejects the servers of an XClient whose calls fail too often
+// with network errors or timeouts. An ejected server is not selected until its
+// ejection time is over, and the ejection time grows each time it is ejected again.
+type OutlierDetection struct {
+	// ConsecutiveFailures ejects a server after this many consecutive failures, 0 disables it.
+	ConsecutiveFailures int
+	// FailureRate ejects a server whose ratio of failed calls in Interval
+	// reaches it, such as 0.5. 0 disables it.
+	FailureRate float64
+	// MinCalls is the number of calls in Interval needed to eject a server by FailureRate.
+	MinCalls int
+	// Interval is the period the failure rate is counted in, 10s by default.
+	Interval time.Duration
+	// BaseEjectionTime is the ejection time of the first ejection, 30s by default.
+	// A server is ejected for BaseEjectionTime times the number of its ejections.
+	BaseEjectionTime time.Duration
+	// MaxEjectionTime is the upper bound of the ejection time, 300s by default.
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent is the max percentage of the servers ejected at the same
+	// time, 10 by default. One server can always be ejected, but the last
+	// available server is never ejected.
+	MaxEjectionPercent int
+}
+
+type outlierStats struct {
+	consecutive int
+	calls       int
+	failures    int
+	windowStart time.Time
+
+	ejections  int
+	ejected    bool
+	restoredAt time.Time
+}
+
+type outlierDetector struct {
+	OutlierDetection
+
+	mu    sync.Mutex
+	stats map[string]*outlierStats
+}
+
+func newOutlierDetector(od *OutlierDetection) *outlierDetector {
+	if od == nil {
+		return nil
+	}
+
+	d := &outlierDetector{OutlierDetection: *od, stats: make(map[string]*outlierStats)}
+	if d.Interval <= 0 {
+		d.Interval = 10 * time.Second
+	}
+	if d.BaseEjectionTime <= 0 {
+		d.BaseEjectionTime = 30 * time.Second
+	}
+	if d.MaxEjectionTime <= 0 {
+		d.MaxEjectionTime = 300 * time.Second
+	}
+	if d.MaxEjectionPercent <= 0 {
+		d.MaxEjectionPercent = 10
+	}
+
+	return d
+}
+
+// isOutlierFailure reports whether err counts as a failure of the server.
+func isOutlierFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return isConnError(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// observe records the result of a call to server k, and reports whether the
+// server should be ejected and the reason.
+func (d *outlierDetector) observe(k string, err error) (bool, string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := d.stats[k]
+	if s == nil {
+		s = &outlierStats{windowStart: time.Now()}
+		d.stats[k] = s
+	}
+	if s.ejected {
+		return false, ""
+	}
+
+	if now := time.Now(); now.Sub(s.windowStart) >= d.Interval {
+		s.windowStart = now
+		s.calls = 0
+		s.failures = 0
+	}
+
+	s.calls++
+	if !isOutlierFailure(err) {
+		s.consecutive = 0
+		return false, ""
+	}
+	s.failures++
+	s.consecutive++
+
+	if d.ConsecutiveFailures > 0 && s.consecutive >= d.ConsecutiveFailures {
+		return true, "consecutive failures"
+	}
+	if d.FailureRate > 0 && s.calls >= d.MinCalls && float64(s.failures) >= d.FailureRate*float64(s.calls) {
+		return true, "failure rate"
+	}
+
+	return false, ""
+}
+
+// eject marks server k as ejected and returns its ejection time.
+func (d *outlierDetector) eject(k string) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := d.stats[k]
+	// 恢复后保持健康足够久, 重新计算弹出次数
+	if !s.restoredAt.IsZero() && time.Since(s.restoredAt) >= d.MaxEjectionTime {
+		s.ejections = 0
+	}
+	s.ejections++
+	s.ejected = true
+	s.consecutive = 0
+	s.calls = 0
+	s.failures = 0
+
+	ejection := d.BaseEjectionTime * time.Duration(s.ejections)
+	if ejection > d.MaxEjectionTime {
+		ejection = d.MaxEjectionTime
+	}
+
+	return ejection
+}
+
+// refused resets the failures of server k, which can not be ejected now.
+func (d *outlierDetector) refused(k string) {
+	d.mu.Lock()
+	if s := d.stats[k]; s != nil {
+		s.consecutive = 0
+	}
+	d.mu.Unlock()
+}
+
+func (d *outlierDetector) restore(k string) {
+	d.mu.Lock()
+	if s := d.stats[k]; s != nil {
+		s.ejected = false
+		s.restoredAt = time.Now()
+		s.windowStart = s.restoredAt
+	}
+	d.mu.Unlock()
+}
+
+// remove forgets the servers that are not in servers.
+func (d *outlierDetector) remove(servers map[string]string) {
+	d.mu.Lock()
+	for k := range d.stats {
+		if _, ok := servers[k]; !ok {
+			delete(d.stats, k)
+		}
+	}
+	d.mu.Unlock()
+}
+
+// observeOutlier records the result of a call to server k for outlier detection,
+// and ejects the server if it is an outlier.
+func (c *xClient) observeOutlier(k string, err error) {
+	if c.outlier == nil || k == "" {
+		return
+	}
+
+	eject, reason := c.outlier.observe(k, err)
+	if !eject {
+		return
+	}
+
+	c.mu.Lock()
+	if _, ok := c.servers[k]; !ok || c.ejected[k] {
+		c.mu.Unlock()
+		return
+	}
+	maxEjected := len(c.servers) * c.outlier.MaxEjectionPercent / 100
+	if maxEjected < 1 {
+		maxEjected = 1
+	}
+	// 不能弹出最后一个可用的服务器
+	if len(c.ejected) >= maxEjected || len(c.availableServersLocked()) <= 1 {
+		c.mu.Unlock()
+		c.outlier.refused(k)
+		return
+	}
+
+	ejection := c.outlier.eject(k)
+	if c.ejected == nil {
+		c.ejected = make(map[string]bool)
+	}
+	c.ejected[k] = true
+	if c.selector != nil {
+		c.selector.UpdateServer(c.availableServersLocked())
+	}
+	c.routeGen++
+	c.mu.Unlock()
+
+	log.Warnf("phobos: eject server %s of %s for %v because of %s", k, c.servicePath, ejection, reason)
+	doServerEjected(c.Plugins, c.servicePath, k, ejection, reason)
+
+	time.AfterFunc(ejection, func() {
+		c.restoreServer(k)
+	})
+}
+
+// restoreServer selects the ejected server k again.
+func (c *xClient) restoreServer(k string) {
+	c.outlier.restore(k)
+
+	c.mu.Lock()
+	if !c.ejected[k] {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.ejected, k)
+	if c.selector != nil {
+		c.selector.UpdateServer(c.availableServersLocked())
+	}
+	c.routeGen++
+	c.mu.Unlock()
+
+	log.Infof("phobos: server %s of %s is restored from ejection", k, c.servicePath)
+	doServerRestored(c.Plugins, c.servicePath, k)
+}
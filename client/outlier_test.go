@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marsevilspirit/phobos/server"
+)
+
+func TestOutlierDetector(t *testing.T) {
+	d := newOutlierDetector(&OutlierDetection{
+		ConsecutiveFailures: 3,
+		BaseEjectionTime:    time.Second,
+		MaxEjectionTime:     3 * time.Second,
+	})
+
+	for i := 0; i < 2; i++ {
+		if eject, _ := d.observe("a", ErrServerUnavailable); eject {
+			t.Fatal("expect not to eject before 3 consecutive failures")
+		}
+	}
+	d.observe("a", nil)
+	d.observe("a", ServiceError("bad args"))
+	d.observe("a", context.DeadlineExceeded)
+	if eject, _ := d.observe("a", ErrServerUnavailable); eject {
+		t.Fatal("expect a success to reset the consecutive failures")
+	}
+	if eject, reason := d.observe("a", ErrServerUnavailable); !eject || reason != "consecutive failures" {
+		t.Fatalf("expect to eject for consecutive failures but got %t, %s", eject, reason)
+	}
+
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if ejection := d.eject("a"); ejection != want {
+			t.Fatalf("expect ejection %d to be %v but got %v", i+1, want, ejection)
+		}
+		d.restore("a")
+	}
+
+	d = newOutlierDetector(&OutlierDetection{FailureRate: 0.5, MinCalls: 10})
+	for i := 0; i < 9; i++ {
+		err := error(nil)
+		if i%2 == 1 {
+			err = ErrServerUnavailable
+		}
+		if eject, _ := d.observe("b", err); eject {
+			t.Fatal("expect not to eject before MinCalls")
+		}
+	}
+	if eject, reason := d.observe("b", ErrServerUnavailable); !eject || reason != "failure rate" {
+		t.Fatalf("expect to eject for failure rate but got %t, %s", eject, reason)
+	}
+}
+
+type ejectionPlugin struct {
+	mu       sync.Mutex
+	ejected  []string
+	restored []string
+}
+
+func (p *ejectionPlugin) DoServerEjected(servicePath, server string, ejection time.Duration, reason string) {
+	p.mu.Lock()
+	p.ejected = append(p.ejected, server)
+	p.mu.Unlock()
+}
+
+func (p *ejectionPlugin) DoServerRestored(servicePath, server string) {
+	p.mu.Lock()
+	p.restored = append(p.restored, server)
+	p.mu.Unlock()
+}
+
+func TestXClient_OutlierDetection(t *testing.T) {
+	var pairs []*KVPair
+	for _, delay := range []time.Duration{0, time.Second, time.Second} {
+		s := server.NewServer()
+		server.RegisterHandler(s, "Arith", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+			time.Sleep(delay)
+			return &Reply{C: args.A * args.B}, nil
+		}, "")
+		go s.Serve("tcp", "127.0.0.1:0")
+		defer s.Close()
+		time.Sleep(200 * time.Millisecond)
+		pairs = append(pairs, &KVPair{Key: "tcp@" + s.Address().String()})
+	}
+
+	opt := DefaultOption
+	opt.BreakerFactory = nil
+	opt.OutlierDetection = &OutlierDetection{
+		ConsecutiveFailures: 2,
+		BaseEjectionTime:    500 * time.Millisecond,
+		MaxEjectionPercent:  50,
+	}
+	xclient := NewXClient("Arith", Failfast, RoundRobin, NewMultipleServersDiscovery(pairs), opt).(*xClient)
+	defer xclient.Close()
+	plugin := &ejectionPlugin{}
+	xclient.Plugins.Add(plugin)
+
+	for i := 0; i < 12; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		xclient.Call(ctx, "Mul", &Args{A: 1, B: 2}, &Reply{})
+		cancel()
+	}
+
+	// 3 个服务器最多弹出 1 个
+	xclient.mu.RLock()
+	ejected := len(xclient.ejected)
+	available := len(xclient.availableServersLocked())
+	xclient.mu.RUnlock()
+	if ejected != 1 || available != 2 {
+		t.Fatalf("expect 1 server to be ejected but got %d ejected and %d available", ejected, available)
+	}
+	plugin.mu.Lock()
+	if len(plugin.ejected) != 1 || plugin.ejected[0] == pairs[0].Key {
+		t.Fatalf("expect a slow server to be ejected but got %v", plugin.ejected)
+	}
+	plugin.mu.Unlock()
+
+	time.Sleep(time.Second)
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+	if len(plugin.restored) != 1 || plugin.restored[0] != plugin.ejected[0] {
+		t.Fatalf("expect the ejected server to be restored but got %v", plugin.restored)
+	}
+}
+
+// callContainer implements only the methods of PluginContainer.
+type callContainer struct {
+	plugins []Plugin
+}
+
+func (p *callContainer) Add(plugin Plugin)    { p.plugins = append(p.plugins, plugin) }
+func (p *callContainer) Remove(plugin Plugin) {}
+func (p *callContainer) All() []Plugin        { return p.plugins }
+func (p *callContainer) DoPreCall(ctx context.Context, servicePath, serviceMethod string, args any) error {
+	return nil
+}
+func (p *callContainer) DoPostCall(ctx context.Context, servicePath, serviceMethod string, args any, reply any, err error) error {
+	return err
+}
+
+func TestDoServerEjected(t *testing.T) {
+	for _, plugins := range []PluginContainer{&pluginContainer{}, &callContainer{}} {
+		plugin := &ejectionPlugin{}
+		plugins.Add(plugin)
+
+		doServerEjected(plugins, "Arith", "tcp@a", time.Second, "consecutive failures")
+		doServerRestored(plugins, "Arith", "tcp@a")
+		if len(plugin.ejected) != 1 || len(plugin.restored) != 1 {
+			t.Fatalf("expect the plugin of %T to be invoked but got %v, %v", plugins, plugin.ejected, plugin.restored)
+		}
+	}
+}
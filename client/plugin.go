@@ -1,6 +1,9 @@
 package client
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type PluginContainer interface {
 	Add(plugin Plugin)
@@ -18,15 +21,27 @@ type pluginContainer struct {
 type Plugin any
 
 type (
-	// PreCallPlugin is invoked before the client calls a server.
+	// PreCallPlugin is invoked before the client calls a server, the call
+	// fails with the error if it returns an error.
 	PreCallPlugin interface {
 		DoPreCall(ctx context.Context, servicePath, serviceMethod string, args any) error
 	}
 
-	// PostCallPlugin is invoked after the client calls a server.
+	// PostCallPlugin is invoked after the client calls a server, the error
+	// it returns replaces the error of the call.
 	PostCallPlugin interface {
 		DoPostCall(ctx context.Context, servicePath, serviceMethod string, args any, reply any, err error) error
 	}
+
+	// ServerEjectedPlugin is invoked after a server is ejected by outlier detection.
+	ServerEjectedPlugin interface {
+		DoServerEjected(servicePath, server string, ejection time.Duration, reason string)
+	}
+
+	// ServerRestoredPlugin is invoked after an ejected server is selected again.
+	ServerRestoredPlugin interface {
+		DoServerRestored(servicePath, server string)
+	}
 )
 
 func (p *pluginContainer) Add(plugin Plugin) {
@@ -67,15 +82,63 @@ func (p *pluginContainer) DoPreCall(ctx context.Context, servicePath, serviceMet
 	return nil
 }
 
-// DoPostCall executes after call
+// DoPostCall executes after call, every plugin gets the error of the call.
+// It returns the first error returned by the plugins.
 func (p *pluginContainer) DoPostCall(ctx context.Context, servicePath, serviceMethod string, args any, reply any, err error) error {
 	for i := range p.plugins {
 		if plugin, ok := p.plugins[i].(PostCallPlugin); ok {
-			err = plugin.DoPostCall(ctx, servicePath, serviceMethod, args, reply, err)
-			if err != nil {
-				return err
+			if e := plugin.DoPostCall(ctx, servicePath, serviceMethod, args, reply, err); e != nil {
+				return e
 			}
 		}
 	}
 	return nil
 }
+
+// DoServerEjected executes after a server is ejected
+func (p *pluginContainer) DoServerEjected(servicePath, server string, ejection time.Duration, reason string) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(ServerEjectedPlugin); ok {
+			plugin.DoServerEjected(servicePath, server, ejection, reason)
+		}
+	}
+}
+
+// DoServerRestored executes after an ejected server is restored
+func (p *pluginContainer) DoServerRestored(servicePath, server string) {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(ServerRestoredPlugin); ok {
+			plugin.DoServerRestored(servicePath, server)
+		}
+	}
+}
+
+// doServerEjected invokes the ServerEjectedPlugin plugins of plugins. The containers
+// implementing ServerEjectedPlugin, like pluginContainer, invoke them by themselves.
+func doServerEjected(plugins PluginContainer, servicePath, server string, ejection time.Duration, reason string) {
+	if p, ok := plugins.(ServerEjectedPlugin); ok {
+		p.DoServerEjected(servicePath, server, ejection, reason)
+		return
+	}
+
+	for _, plugin := range plugins.All() {
+		if p, ok := plugin.(ServerEjectedPlugin); ok {
+			p.DoServerEjected(servicePath, server, ejection, reason)
+		}
+	}
+}
+
+// doServerRestored invokes the ServerRestoredPlugin plugins of plugins. The containers
+// implementing ServerRestoredPlugin, like pluginContainer, invoke them by themselves.
+func doServerRestored(plugins PluginContainer, servicePath, server string) {
+	if p, ok := plugins.(ServerRestoredPlugin); ok {
+		p.DoServerRestored(servicePath, server)
+		return
+	}
+
+	for _, plugin := range plugins.All() {
+		if p, ok := plugin.(ServerRestoredPlugin); ok {
+			p.DoServerRestored(servicePath, server)
+		}
+	}
+}
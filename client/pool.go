@@ -0,0 +1,205 @@
+package client
+
+import (
+	"math"
+	"sync"
+
+	"github.com/marsevilspirit/phobos/log"
+)
+
+// ConnPoolMode decides which connection to a server is used by a call
+// when Option.ConnectionsPerServer is greater than 1.
+type ConnPoolMode int
+
+const (
+	// PoolLeastPending uses the connection with the fewest pending calls.
+	PoolLeastPending ConnPoolMode = iota
+	// PoolRoundRobin uses the connections in turn.
+	PoolRoundRobin
+)
+
+// connPool holds the connections to a server. It creates a new connection
+// only when all the connections are busy, up to size connections.
+type connPool struct {
+	size int
+	mode ConnPoolMode
+	dial func() (RPCClient, error)
+
+	mu      sync.Mutex
+	clients []RPCClient
+	next    int
+	// 正在建立的连接数, 建立连接时不持有锁
+	dialing int
+	// 有连接建立完成(成功或失败)时关闭
+	dialed chan struct{}
+	closed bool
+}
+
+func newConnPool(size int, mode ConnPoolMode, dial func() (RPCClient, error)) *connPool {
+	if size < 1 {
+		size = 1
+	}
+
+	return &connPool{size: size, mode: mode, dial: dial}
+}
+
+func (p *connPool) get() (RPCClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var least, leastPending int
+	for {
+		// 只替换断开的连接, 其它连接继续使用
+		live := p.clients[:0]
+		for _, client := range p.clients {
+			if client.IsClosing() || client.IsShutdown() {
+				client.UnregisterServerMessageChan()
+				client.Close()
+				continue
+			}
+			live = append(live, client)
+		}
+		clear(p.clients[len(live):])
+		p.clients = live
+
+		// 优先使用已经连接的客户端, 重连中的客户端最后使用
+		least, leastPending = -1, 0
+		for i, client := range p.clients {
+			n := pendingCalls(client)
+			if !isReady(client) {
+				n = math.MaxInt
+			}
+			if least < 0 || n < leastPending {
+				least, leastPending = i, n
+			}
+		}
+
+		if (least < 0 || leastPending > 0) && len(p.clients)+p.dialing < p.size {
+			p.dialing++
+			if least >= 0 {
+				// 在后台建立新连接, 这次调用先使用已有的连接
+				go p.grow()
+				break
+			}
+
+			p.mu.Unlock()
+			client, err := p.dial()
+			p.mu.Lock()
+			if err = p.dialDoneLocked(client, err); err != nil {
+				return nil, err
+			}
+			return client, nil
+		}
+
+		if least >= 0 {
+			break
+		}
+
+		// 没有可用的连接, 等待正在建立的连接
+		if p.dialed == nil {
+			p.dialed = make(chan struct{})
+		}
+		dialed := p.dialed
+		p.mu.Unlock()
+		<-dialed
+		p.mu.Lock()
+	}
+
+	if p.mode == PoolRoundRobin && leastPending != math.MaxInt {
+		for range p.clients {
+			p.next = (p.next + 1) % len(p.clients)
+			if isReady(p.clients[p.next]) {
+				break
+			}
+		}
+		return p.clients[p.next], nil
+	}
+
+	return p.clients[least], nil
+}
+
+// grow adds a new connection to the pool in background.
+func (p *connPool) grow() {
+	client, err := p.dial()
+	if err != nil {
+		log.Warnf("phobos: failed to add a connection to the pool: %v", err)
+	}
+
+	p.mu.Lock()
+	p.dialDoneLocked(client, err)
+	p.mu.Unlock()
+}
+
+// dialDoneLocked adds the dialed client to the pool and wakes up the calls
+// waiting for it. It returns ErrShutdown if the pool has been closed.
+// p.mu must be held.
+func (p *connPool) dialDoneLocked(client RPCClient, err error) error {
+	p.dialing--
+	if p.dialed != nil {
+		close(p.dialed)
+		p.dialed = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if p.closed {
+		client.Close()
+		return ErrShutdown
+	}
+	p.clients = append(p.clients, client)
+	return nil
+}
+
+// remove closes client and removes it from the pool.
+func (p *connPool) remove(client RPCClient) {
+	p.mu.Lock()
+	for i, c := range p.clients {
+		if c == client {
+			p.clients = append(p.clients[:i], p.clients[i+1:]...)
+			break
+		}
+	}
+	p.mu.Unlock()
+
+	client.UnregisterServerMessageChan()
+	client.Close()
+}
+
+func (p *connPool) close() []error {
+	p.mu.Lock()
+	clients := p.clients
+	p.clients = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	var errs []error
+	for _, client := range clients {
+		if err := client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// pendingCalls returns the number of calls and streams waiting for the server.
+func pendingCalls(client RPCClient) int {
+	if c, ok := client.(*Client); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.pending) + len(c.streams)
+	}
+
+	return 0
+}
+
+// isReady reports whether the connection of client is not being reconnected.
+func isReady(client RPCClient) bool {
+	if c, ok := client.(interface{ State() ConnState }); ok {
+		s := c.State()
+		return s != Connecting && s != TransientFailure
+	}
+
+	return true
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marsevilspirit/phobos/server"
+)
+
+func TestConnPool(t *testing.T) {
+	s := server.NewServer()
+	server.RegisterHandler(s, "Arith", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+		time.Sleep(100 * time.Millisecond)
+		return &Reply{C: args.A * args.B}, nil
+	}, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	k := "tcp@" + s.Address().String()
+	opt := DefaultOption
+	opt.ConnectionsPerServer = 4
+	xclient := NewXClient("Arith", Failtry, RandomSelect, NewP2PDiscovery(k, ""), opt).(*xClient)
+	defer xclient.Close()
+
+	// 单个请求不会创建多余的连接
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 1, B: 2}, &Reply{}); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	pool := xclient.pools[k]
+	if n := len(pool.clients); n != 1 {
+		t.Fatalf("expect 1 connection but got %d", n)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := xclient.Call(context.Background(), "Mul", &Args{A: 1, B: 2}, &Reply{}); err != nil {
+				t.Errorf("failed to call: %v", err)
+			}
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+
+	pool.mu.Lock()
+	clients := append([]RPCClient(nil), pool.clients...)
+	pool.mu.Unlock()
+	if len(clients) != 4 {
+		t.Fatalf("expect 4 connections but got %d", len(clients))
+	}
+
+	// 只替换断开的连接
+	clients[0].Close()
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 1, B: 2}, &Reply{}); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if len(pool.clients) != 3 {
+		t.Fatalf("expect 3 connections but got %d", len(pool.clients))
+	}
+	for i, client := range pool.clients {
+		if client != clients[i+1] {
+			t.Fatal("expect the other connections to be kept")
+		}
+	}
+}
+
+func TestConnPool_DialWithoutLock(t *testing.T) {
+	busy := &Client{pending: map[uint64]*Call{1: {}}}
+	release := make(chan struct{})
+	var dials atomic.Int32
+	pool := newConnPool(2, PoolLeastPending, func() (RPCClient, error) {
+		if dials.Add(1) == 1 {
+			return busy, nil
+		}
+		<-release
+		return &Client{}, nil
+	})
+
+	if c, err := pool.get(); err != nil || c != busy {
+		t.Fatalf("expect the first connection, got %v", err)
+	}
+
+	// 建立新连接时不阻塞其它调用
+	done := make(chan RPCClient)
+	go func() {
+		for i := 0; i < 3; i++ {
+			c, _ := pool.get()
+			done <- c
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		select {
+		case c := <-done:
+			if c != busy {
+				t.Fatal("expect the existing connection while dialing")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("get is blocked by the dial")
+		}
+	}
+	pool.mu.Lock()
+	dialing := pool.dialing
+	pool.mu.Unlock()
+	if dialing != 1 {
+		t.Fatalf("expect 1 dial in background but got %d", dialing)
+	}
+
+	close(release)
+	for i := 0; ; i++ {
+		pool.mu.Lock()
+		n := len(pool.clients)
+		pool.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("expect 2 connections but got %d", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c, _ := pool.get(); c == busy {
+		t.Fatal("expect the new idle connection to be used")
+	}
+}
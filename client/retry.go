@@ -0,0 +1,243 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marsevilspirit/phobos/breaker"
+	ex "github.com/marsevilspirit/phobos/errors"
+)
+
+// RetryPolicy decides whether and when a failed call of Failtry and Failover is retried.
+// The number of attempts is still limited by Option.Retries.
+type RetryPolicy interface {
+	// Retryable reports whether a call failed with err can be retried.
+	Retryable(err error) bool
+	// Backoff returns how long to wait before the n-th retry, n starts from 1.
+	Backoff(n int) time.Duration
+}
+
+// BackoffRetryPolicy retries the calls failed by network errors or with one of
+// RetryableCodes, waiting exponentially longer between the retries.
+type BackoffRetryPolicy struct {
+	// BaseDelay is the delay before the first retry.
+	BaseDelay time.Duration
+	// MaxDelay is the upper bound of the delay.
+	MaxDelay time.Duration
+	// Multiplier is the factor the delay grows by after each retry, 2 if it is not greater than 1.
+	Multiplier float64
+	// Jitter randomizes the delay by up to ±Jitter of it, such as 0.2.
+	Jitter float64
+	// RetryableCodes are the error codes of the errors returned by the
+	// server which can be retried. Errors without a code are not retried.
+	RetryableCodes []ex.ErrorCode
+}
+
+// DefaultRetryPolicy is the RetryPolicy of DefaultOption.
+var DefaultRetryPolicy RetryPolicy = &BackoffRetryPolicy{
+	BaseDelay:      50 * time.Millisecond,
+	MaxDelay:       2 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	RetryableCodes: []ex.ErrorCode{ex.ErrCodeServiceUnavailable, ex.ErrCodeTimeout},
+}
+
+func (p *BackoffRetryPolicy) Retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if code, ok := ErrorCodeOf(err); ok {
+		for _, c := range p.RetryableCodes {
+			if c == code {
+				return true
+			}
+		}
+		return false
+	}
+
+	// 没有错误码的业务错误不重试, 其它的是网络错误或熔断错误
+	_, ok := err.(ServiceError)
+	return !ok
+}
+
+func (p *BackoffRetryPolicy) Backoff(n int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+
+	delay := float64(p.BaseDelay) * math.Pow(multiplier, float64(n-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay *= 1 + p.Jitter*(rand.Float64()*2-1)
+	}
+
+	return time.Duration(delay)
+}
+
+// ErrorCodeOf returns the error code of err if it is an *errors.Error, or a
+// ServiceError returned by a server for an *errors.Error.
+func ErrorCodeOf(err error) (ex.ErrorCode, bool) {
+	var e *ex.Error
+	if errors.As(err, &e) {
+		return e.Code, true
+	}
+
+	var se ServiceError
+	if !errors.As(err, &se) {
+		return 0, false
+	}
+
+	// 服务端返回的是 errors.Error 的 Error(), 格式为 "[code] message"
+	s := string(se)
+	if !strings.HasPrefix(s, "[") {
+		return 0, false
+	}
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return 0, false
+	}
+	code, err := strconv.Atoi(s[1:end])
+	if err != nil {
+		return 0, false
+	}
+
+	return ex.ErrorCode(code), true
+}
+
+// IsRateLimited reports whether err is a rejection of a server that is
+// overloaded or limits the rate of the calls. Such errors don't count as
+// failures of the server for the circuit breaker.
+func IsRateLimited(err error) bool {
+	code, ok := ErrorCodeOf(err)
+	return ok && code == ex.ErrCodeRateLimitExceeded
+}
+
+// RetryBudget limits the retries of an XClient to a ratio of its calls,
+// so retries don't overload servers that are already failing.
+type RetryBudget struct {
+	// Ratio is the max ratio of retries to calls, such as 0.1.
+	Ratio float64
+	// MinRetriesPerSecond allows some retries when there are few calls.
+	MinRetriesPerSecond int
+}
+
+// retryBudgetWindow is the period the calls and retries are counted in.
+const retryBudgetWindow = 10 * time.Second
+
+type retryBudget struct {
+	RetryBudget
+
+	mu      sync.Mutex
+	start   time.Time
+	calls   int
+	retries int
+}
+
+func newRetryBudget(b *RetryBudget) *retryBudget {
+	if b == nil {
+		return nil
+	}
+
+	return &retryBudget{RetryBudget: *b, start: time.Now()}
+}
+
+func (b *retryBudget) resetLocked(now time.Time) {
+	if now.Sub(b.start) >= retryBudgetWindow {
+		b.start = now
+		b.calls = 0
+		b.retries = 0
+	}
+}
+
+// call records a call, it is safe to call on a nil budget.
+func (b *retryBudget) call() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.resetLocked(time.Now())
+	b.calls++
+	b.mu.Unlock()
+}
+
+// retry reports whether a retry is allowed, and records it if so.
+// A nil budget allows all retries.
+func (b *retryBudget) retry() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.resetLocked(time.Now())
+	allowed := b.Ratio*float64(b.calls) + float64(b.MinRetriesPerSecond)*retryBudgetWindow.Seconds()
+	if float64(b.retries) >= allowed {
+		return false
+	}
+	b.retries++
+
+	return true
+}
+
+// shouldRetry reports whether a call failed with err for the n-th time should
+// be retried, and waits for the backoff of the retry policy if so.
+func (c *xClient) shouldRetry(ctx context.Context, n int, err error) bool {
+	if n >= c.option.Retries || ctx.Err() != nil {
+		return false
+	}
+
+	policy := c.option.RetryPolicy
+	if policy == nil {
+		_, ok := err.(ServiceError)
+		return !ok && c.retryBudget.retry()
+	}
+
+	if !policy.Retryable(err) {
+		return false
+	}
+
+	delay := policy.Backoff(n)
+	// 等不到重试就会超时
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+		return false
+	}
+	if !c.retryBudget.retry() {
+		return false
+	}
+
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-t.C:
+		}
+	}
+
+	return true
+}
+
+// isConnError reports whether err means the connection to the server is broken.
+func isConnError(err error) bool {
+	if _, ok := err.(ServiceError); ok {
+		return false
+	}
+
+	return !errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded) &&
+		err != breaker.ErrOpenState &&
+		err != breaker.ErrTooManyRequests
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marsevilspirit/phobos/breaker"
+	ex "github.com/marsevilspirit/phobos/errors"
+	"github.com/marsevilspirit/phobos/server"
+)
+
+func TestBackoffRetryPolicy(t *testing.T) {
+	p := &BackoffRetryPolicy{
+		BaseDelay:      10 * time.Millisecond,
+		MaxDelay:       50 * time.Millisecond,
+		Multiplier:     2,
+		RetryableCodes: []ex.ErrorCode{ex.ErrCodeServiceUnavailable},
+	}
+
+	for n, want := range []time.Duration{10, 20, 40, 50, 50} {
+		if d := p.Backoff(n + 1); d != want*time.Millisecond {
+			t.Errorf("expect backoff %v of retry %d but got %v", want*time.Millisecond, n+1, d)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.Backoff(1); d < 5*time.Millisecond || d > 15*time.Millisecond {
+			t.Fatalf("expect backoff within jitter but got %v", d)
+		}
+	}
+
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{ex.ErrServiceUnavailable, true},
+		{ex.New(ex.ErrCodeValidationFailed, "bad args"), false},
+		{ServiceError(ex.ErrServiceUnavailable.Error()), true},
+		{ServiceError(ex.New(ex.ErrCodeValidationFailed, "bad args").Error()), false},
+		{ServiceError("some error"), false},
+		{context.DeadlineExceeded, false},
+		{ErrServerUnavailable, true},
+		{breaker.ErrOpenState, true},
+	}
+	for _, c := range cases {
+		if got := p.Retryable(c.err); got != c.want {
+			t.Errorf("expect Retryable(%v) to be %t", c.err, c.want)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := newRetryBudget(&RetryBudget{Ratio: 0.1})
+	for i := 0; i < 20; i++ {
+		b.call()
+	}
+	if !b.retry() || !b.retry() {
+		t.Fatal("expect 2 retries of 20 calls to be allowed")
+	}
+	if b.retry() {
+		t.Fatal("expect the third retry to exceed the budget")
+	}
+
+	var nilBudget *retryBudget
+	nilBudget.call()
+	if !nilBudget.retry() {
+		t.Fatal("expect nil budget to allow retries")
+	}
+}
+
+func TestXClient_RetryPolicy(t *testing.T) {
+	var calls, failures atomic.Int32
+	s := server.NewServer()
+	server.RegisterHandler(s, "Arith", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+		calls.Add(1)
+		if failures.Add(-1) >= 0 {
+			return nil, ex.ErrServiceUnavailable
+		}
+		return &Reply{C: args.A * args.B}, nil
+	}, "")
+	server.RegisterHandler(s, "Arith", "Div", func(ctx context.Context, args *Args) (*Reply, error) {
+		calls.Add(1)
+		return nil, ex.New(ex.ErrCodeValidationFailed, "divided by zero")
+	}, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	opt := DefaultOption
+	opt.RetryPolicy = &BackoffRetryPolicy{
+		BaseDelay:      10 * time.Millisecond,
+		RetryableCodes: []ex.ErrorCode{ex.ErrCodeServiceUnavailable},
+	}
+	xclient := NewXClient("Arith", Failtry, RandomSelect, NewP2PDiscovery("tcp@"+s.Address().String(), ""), opt)
+	defer xclient.Close()
+
+	failures.Store(2)
+	reply := &Reply{}
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, reply); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+	if reply.C != 200 || calls.Load() != 3 {
+		t.Fatalf("expect 3 calls and result 200 but got %d calls and %d", calls.Load(), reply.C)
+	}
+
+	calls.Store(0)
+	err := xclient.Call(context.Background(), "Div", &Args{A: 10, B: 0}, reply)
+	if code, ok := ErrorCodeOf(err); !ok || code != ex.ErrCodeValidationFailed {
+		t.Fatalf("expect validation error but got %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expect validation error not to be retried but got %d calls", calls.Load())
+	}
+
+	// 剩余时间不够退避时不再重试
+	calls.Store(0)
+	failures.Store(3)
+	opt.RetryPolicy = &BackoffRetryPolicy{
+		BaseDelay:      time.Second,
+		RetryableCodes: []ex.ErrorCode{ex.ErrCodeServiceUnavailable},
+	}
+	xclient2 := NewXClient("Arith", Failtry, RandomSelect, NewP2PDiscovery("tcp@"+s.Address().String(), ""), opt)
+	defer xclient2.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err = xclient2.Call(ctx, "Mul", &Args{A: 10, B: 20}, reply)
+	if code, ok := ErrorCodeOf(err); !ok || code != ex.ErrCodeServiceUnavailable || calls.Load() != 1 {
+		t.Fatalf("expect 1 call failed with service unavailable but got %d calls and %v", calls.Load(), err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expect to return before the deadline")
+	}
+}
+
+func TestIsRateLimited(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{ServiceError(server.ErrServerOverloaded.Error()), true},
+		{ServiceError(ex.New(ex.ErrCodeRateLimitExceeded, "rate limit exceeded").Error()), true},
+		{ServiceError(ex.ErrServiceUnavailable.Error()), false},
+		{ErrServerUnavailable, false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if got := IsRateLimited(c.err); got != c.want {
+			t.Errorf("expect IsRateLimited(%v) to be %t", c.err, c.want)
+		}
+		if c.want && !defaultBreakerSettings.IsSuccessful(c.err) {
+			t.Errorf("expect %v not to count as a failure of the breaker", c.err)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/marsevilspirit/phobos/share"
+	"github.com/valyala/fastrand"
+)
+
+// RouteRule filters the servers a call can be sent to before the selector selects one.
+type RouteRule interface {
+	// Route returns the servers of servers the call can be sent to.
+	// servers maps the servers to their metadata, it must not be modified.
+	Route(ctx context.Context, servicePath, serviceMethod string, servers map[string]string) map[string]string
+}
+
+// MetadataRule routes the calls by the metadata of the servers in the service discovery,
+// such as "version=v2&zone=cn-east".
+//
+// Examples:
+//
+//	// 只使用 v2 版本的服务器
+//	&MetadataRule{Match: map[string]string{"version": "v2"}}
+//	// 优先使用同一个区域的服务器
+//	&MetadataRule{Match: map[string]string{"zone": "cn-east"}, Fallback: true}
+//	// 请求元数据中 tenant=gold 的请求发往 pool=gold 的服务器
+//	&MetadataRule{When: map[string]string{"tenant": "gold"}, Match: map[string]string{"pool": "gold"}}
+type MetadataRule struct {
+	// When is the request metadata a call must have for the rule to apply.
+	// The rule applies to all calls if it is empty.
+	When map[string]string
+	// Match is the metadata the servers must have.
+	Match map[string]string
+	// Fallback uses all the servers if no server matches, otherwise the call
+	// fails with ErrXClientNoServer.
+	Fallback bool
+}
+
+func (r *MetadataRule) Route(ctx context.Context, servicePath, serviceMethod string, servers map[string]string) map[string]string {
+	if len(r.When) > 0 {
+		meta, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+		for k, v := range r.When {
+			if meta[k] != v {
+				return servers
+			}
+		}
+	}
+
+	matched := make(map[string]string)
+	for server, metadata := range servers {
+		if matchMetadata(metadata, r.Match) {
+			matched[server] = metadata
+		}
+	}
+
+	if len(matched) == 0 && r.Fallback {
+		return servers
+	}
+
+	return matched
+}
+
+func matchMetadata(metadata string, match map[string]string) bool {
+	v, err := url.ParseQuery(metadata)
+	if err != nil {
+		return false
+	}
+
+	for k, want := range match {
+		if v.Get(k) != want {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Split is a group of servers and the weight of the calls sent to them.
+type Split struct {
+	// Value is the metadata value of the servers of the group.
+	Value string
+	// Weight is the weight of the calls of the group.
+	Weight int
+}
+
+// SplitRule splits the calls between groups of servers by the value of Key in
+// their metadata, such as 95% of the calls to version=1.4 and 5% to version=1.5:
+//
+//	&SplitRule{Key: "version", Splits: []Split{{"1.4", 95}, {"1.5", 5}}, StickyKey: "user"}
+//
+// Servers not in any group are not used. Groups without servers are skipped and
+// their calls go to the other groups. The rule doesn't apply if no group has servers.
+type SplitRule struct {
+	// Key is the metadata the servers are grouped by.
+	Key string
+	// Splits are the groups of servers.
+	Splits []Split
+	// StickyKey is the request metadata assigning the calls to the groups,
+	// the calls with the same value are always sent to the same group.
+	// The calls without it are assigned randomly.
+	StickyKey string
+}
+
+func (r *SplitRule) Route(ctx context.Context, servicePath, serviceMethod string, servers map[string]string) map[string]string {
+	groups := make([]map[string]string, len(r.Splits))
+	total := 0
+	for i, split := range r.Splits {
+		if split.Weight <= 0 {
+			continue
+		}
+		for server, metadata := range servers {
+			if matchMetadata(metadata, map[string]string{r.Key: split.Value}) {
+				if groups[i] == nil {
+					groups[i] = make(map[string]string)
+				}
+				groups[i][server] = metadata
+			}
+		}
+		if groups[i] != nil {
+			total += split.Weight
+		}
+	}
+	if total == 0 {
+		return servers
+	}
+
+	var n int
+	meta, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	if v, ok := meta[r.StickyKey]; ok && r.StickyKey != "" {
+		n = int(HashString(v) % uint64(total))
+	} else {
+		n = int(fastrand.Uint32n(uint32(total)))
+	}
+
+	for i, split := range r.Splits {
+		if groups[i] == nil {
+			continue
+		}
+		if n < split.Weight {
+			return groups[i]
+		}
+		n -= split.Weight
+	}
+
+	return servers
+}
+
+// maxRouteSelectors limits the selectors of the routed servers kept by an XClient.
+const maxRouteSelectors = 64
+
+// SetRouteRules replaces the route rules of the client. The rules are applied
+// in order to the servers of every call, before the selector selects one of them.
+func (c *xClient) SetRouteRules(rules ...RouteRule) {
+	c.mu.Lock()
+	c.routeRules = rules
+	c.routeGen++
+	c.mu.Unlock()
+}
+
+// routeLocked returns the servers of servers a call can be sent to, c.mu must be held.
+func (c *xClient) routeLocked(ctx context.Context, serviceMethod string, servers map[string]string) map[string]string {
+	for _, rule := range c.routeRules {
+		if len(servers) == 0 {
+			break
+		}
+		servers = rule.Route(ctx, c.servicePath, serviceMethod, servers)
+	}
+
+	return servers
+}
+
+// routedSelector is the selector of a group of routed servers.
+type routedSelector struct {
+	servers map[string]string
+	Selector
+}
+
+// routeSelector returns the selector selecting among the routed servers, so the
+// selectors keep working as if there were only these servers. It returns nil if
+// the selector is set by the user and can not be created for the servers.
+//
+// The selectors are kept until gen, the generation of the servers and the rules
+// of the client, changes. In a generation the servers are identified by their
+// names only, their metadata doesn't change.
+func (c *xClient) routeSelector(gen uint64, servers map[string]string) Selector {
+	if c.selectMode == SelectByUser {
+		return nil
+	}
+
+	// 与顺序无关, 不需要排序
+	var key uint64
+	for k := range servers {
+		key += HashString(k)
+	}
+
+	c.routeSelectorsMu.Lock()
+	defer c.routeSelectorsMu.Unlock()
+
+	if c.routeSelectorsGen != gen {
+		c.routeSelectorsGen = gen
+		c.routeSelectors = nil
+	}
+	if s, ok := c.routeSelectors[key]; ok && sameServers(s.servers, servers) {
+		return s.Selector
+	}
+
+	var selector Selector
+	if c.selectMode == Closest {
+		selector = newGeoSelector(servers, c.latitude, c.longitude)
+	} else {
+		selector = newSelector(c.selectMode, servers)
+	}
+	if c.routeSelectors == nil || len(c.routeSelectors) >= maxRouteSelectors {
+		c.routeSelectors = make(map[uint64]*routedSelector)
+	}
+	c.routeSelectors[key] = &routedSelector{servers: servers, Selector: selector}
+
+	return selector
+}
+
+func sameServers(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// callObservers returns the selectors observing the calls.
+func (c *xClient) callObservers() []CallObserver {
+	var observers []CallObserver
+
+	c.mu.RLock()
+	if o, ok := c.selector.(CallObserver); ok {
+		observers = append(observers, o)
+	}
+	c.mu.RUnlock()
+
+	c.routeSelectorsMu.Lock()
+	for _, s := range c.routeSelectors {
+		if o, ok := s.Selector.(CallObserver); ok {
+			observers = append(observers, o)
+		}
+	}
+	c.routeSelectorsMu.Unlock()
+
+	return observers
+}
+
+// selectServerLocked selects a server among the routed servers, and selects
+// again if skip reports true, up to the number of the servers. c.mu must be held.
+func (c *xClient) selectServerLocked(ctx context.Context, servicePath, serviceMethod string, args any, skip func(k string) bool) string {
+	n := len(c.servers)
+	selectOnce := func() string {
+		return c.selector.Select(ctx, servicePath, serviceMethod, args)
+	}
+
+	if len(c.routeRules) > 0 {
+		available := c.availableServersLocked()
+		servers := c.routeLocked(ctx, serviceMethod, available)
+		if len(servers) == 0 {
+			return ""
+		}
+		// 规则没有过滤掉服务器时直接使用 c.selector
+		if len(servers) < len(available) {
+			n = len(servers)
+			selectOnce = c.routeSelectFunc(ctx, servicePath, serviceMethod, args, servers)
+		}
+	}
+
+	k := selectOnce()
+	for i := 1; i < n && k != "" && skip(k); i++ {
+		k = selectOnce()
+	}
+
+	return k
+}
+
+// routeSelectFunc returns the function selecting a server of the routed servers.
+func (c *xClient) routeSelectFunc(ctx context.Context, servicePath, serviceMethod string, args any, servers map[string]string) func() string {
+	if selector := c.routeSelector(c.routeGen, servers); selector != nil {
+		return func() string {
+			return selector.Select(ctx, servicePath, serviceMethod, args)
+		}
+	}
+
+	// 用户的选择器只能在所有服务器中选择, 选到路由之外的服务器时重新选择
+	return func() string {
+		for i := 0; i < len(c.servers); i++ {
+			if k := c.selector.Select(ctx, servicePath, serviceMethod, args); hasServer(servers, k) {
+				return k
+			}
+		}
+		for k := range servers {
+			return k
+		}
+		return ""
+	}
+}
+
+func hasServer(servers map[string]string, k string) bool {
+	_, ok := servers[k]
+	return ok
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marsevilspirit/phobos/server"
+	"github.com/marsevilspirit/phobos/share"
+)
+
+func TestXClient_RouteRules(t *testing.T) {
+	metadata := []string{"version=v1&zone=a", "version=v2&zone=b", "version=v2&zone=a&pool=gold"}
+	calls := make([]atomic.Int32, len(metadata))
+	var pairs []*KVPair
+	for i, meta := range metadata {
+		s := server.NewServer()
+		server.RegisterHandler(s, "Arith", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+			calls[i].Add(1)
+			return &Reply{C: i}, nil
+		}, "")
+		go s.Serve("tcp", "127.0.0.1:0")
+		defer s.Close()
+		time.Sleep(200 * time.Millisecond)
+		pairs = append(pairs, &KVPair{Key: "tcp@" + s.Address().String(), Value: meta})
+	}
+
+	xclient := NewXClient("Arith", Failfast, RoundRobin, NewMultipleServersDiscovery(pairs), DefaultOption)
+	defer xclient.Close()
+
+	selected := func(ctx context.Context) map[int]bool {
+		t.Helper()
+		servers := make(map[int]bool)
+		for i := 0; i < 10; i++ {
+			reply := &Reply{}
+			if err := xclient.Call(ctx, "Mul", &Args{}, reply); err != nil {
+				t.Fatalf("failed to call: %v", err)
+			}
+			servers[reply.C] = true
+		}
+		return servers
+	}
+
+	xclient.SetRouteRules(&MetadataRule{Match: map[string]string{"version": "v2"}})
+	if got := selected(context.Background()); len(got) != 2 || !got[1] || !got[2] {
+		t.Fatalf("expect v2 servers to be selected but got %v", got)
+	}
+
+	// 优先同区域, 没有时使用任意服务器
+	xclient.SetRouteRules(&MetadataRule{Match: map[string]string{"zone": "a"}, Fallback: true})
+	if got := selected(context.Background()); len(got) != 2 || !got[0] || !got[2] {
+		t.Fatalf("expect zone a servers to be selected but got %v", got)
+	}
+	xclient.SetRouteRules(&MetadataRule{Match: map[string]string{"zone": "c"}, Fallback: true})
+	if got := selected(context.Background()); len(got) != 3 {
+		t.Fatalf("expect all servers to be selected but got %v", got)
+	}
+
+	xclient.SetRouteRules(&MetadataRule{
+		When:  map[string]string{"tenant": "gold"},
+		Match: map[string]string{"pool": "gold"},
+	})
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"tenant": "gold"})
+	if got := selected(ctx); len(got) != 1 || !got[2] {
+		t.Fatalf("expect the gold server to be selected but got %v", got)
+	}
+	if got := selected(context.Background()); len(got) != 3 {
+		t.Fatalf("expect all servers to be selected but got %v", got)
+	}
+
+	xclient.SetRouteRules(&MetadataRule{Match: map[string]string{"version": "v3"}})
+	if err := xclient.Call(context.Background(), "Mul", &Args{}, &Reply{}); err != ErrXClientNoServer {
+		t.Fatalf("expect ErrXClientNoServer but got %v", err)
+	}
+
+	for i := range calls {
+		calls[i].Store(0)
+	}
+	xclient.SetRouteRules(&MetadataRule{Match: map[string]string{"version": "v2"}})
+	if err := xclient.Broadcast(context.Background(), "Mul", &Args{}, &Reply{}); err != nil {
+		t.Fatalf("failed to broadcast: %v", err)
+	}
+	if calls[0].Load() != 0 || calls[1].Load() != 1 || calls[2].Load() != 1 {
+		t.Fatalf("expect only v2 servers to be called but got %d, %d, %d", calls[0].Load(), calls[1].Load(), calls[2].Load())
+	}
+}
+
+func TestSplitRule(t *testing.T) {
+	servers := map[string]string{
+		"a": "version=1.4",
+		"b": "version=1.4&zone=x",
+		"c": "version=1.5",
+		"d": "version=1.6",
+	}
+	rule := &SplitRule{Key: "version", Splits: []Split{{"1.4", 90}, {"1.5", 10}}, StickyKey: "user"}
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		routed := rule.Route(context.Background(), "Arith", "Mul", servers)
+		if len(routed) == 2 && routed["a"] != "" && routed["b"] != "" {
+			counts["1.4"]++
+		} else if len(routed) == 1 && routed["c"] != "" {
+			counts["1.5"]++
+		} else {
+			t.Fatalf("unexpected servers %v", routed)
+		}
+	}
+	if counts["1.5"] < 700 || counts["1.5"] > 1300 {
+		t.Fatalf("expect about 10%% of calls to 1.5 but got %v", counts)
+	}
+
+	// 同一个用户总是发往同一个版本
+	for _, user := range []string{"alice", "bob", "carol"} {
+		ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"user": user})
+		want := rule.Route(ctx, "Arith", "Mul", servers)
+		for i := 0; i < 100; i++ {
+			if got := rule.Route(ctx, "Arith", "Mul", servers); len(got) != len(want) {
+				t.Fatalf("expect %s to stick to %v but got %v", user, want, got)
+			}
+		}
+	}
+
+	// 没有服务器的分组的流量发往其它分组
+	delete(servers, "c")
+	for i := 0; i < 100; i++ {
+		if routed := rule.Route(context.Background(), "Arith", "Mul", servers); len(routed) != 2 || routed["d"] != "" {
+			t.Fatalf("expect 1.4 servers but got %v", routed)
+		}
+	}
+}
+
+func TestXClient_RouteSelectorGeneration(t *testing.T) {
+	c := &xClient{selectMode: RoundRobin}
+	servers := map[string]string{"tcp@a": "", "tcp@b": ""}
+
+	s := c.routeSelector(1, servers)
+	if got := c.routeSelector(1, map[string]string{"tcp@b": "", "tcp@a": ""}); got != s {
+		t.Fatalf("expect the selector of the same servers to be reused")
+	}
+	if got := c.routeSelector(1, map[string]string{"tcp@a": ""}); got == s {
+		t.Fatalf("expect a new selector for other servers")
+	}
+	if got := c.routeSelector(2, servers); got == s {
+		t.Fatalf("expect a new selector in a new generation")
+	}
+	if len(c.routeSelectors) != 1 {
+		t.Fatalf("expect the selectors of the old generation to be removed but got %d", len(c.routeSelectors))
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"math/rand"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/marsevilspirit/phobos/share"
 	"github.com/valyala/fastrand"
 )
 
@@ -16,6 +18,15 @@ type Selector interface {
 	UpdateServer(servers map[string]string)
 }
 
+// CallObserver is implemented by the selectors which select servers by their load.
+// XClient notifies the selector of every call to a server if it is a CallObserver.
+type CallObserver interface {
+	// CallStarted is invoked before a call is sent to server.
+	CallStarted(server string)
+	// CallFinished is invoked after the call to server returns.
+	CallFinished(server string, latency time.Duration, err error)
+}
+
 func newSelector(selectMode SelectMode, servers map[string]string) Selector {
 	switch selectMode {
 	case RandomSelect:
@@ -32,6 +43,10 @@ func newSelector(selectMode SelectMode, servers map[string]string) Selector {
 		return newConsistentHashSelector(servers)
 	case SelectByUser:
 		return nil
+	case LeastActive:
+		return newLeastActiveSelector(servers)
+	case P2CEWMA:
+		return newP2CEWMASelector(servers)
 	default:
 		return newRandomSelector(servers)
 	}
@@ -238,37 +253,67 @@ func createGeoServer(servers map[string]string) []*geoServer {
 	return geoServers
 }
 
+// HashKeyFunc returns the key of a call, calls with the same key are sent to
+// the same server by the ConsistentHash selector.
+type HashKeyFunc func(ctx context.Context, servicePath, serviceMethod string, args any) string
+
+// HashKeyer is implemented by the args which provide their own hash key.
+type HashKeyer interface {
+	HashKey() string
+}
+
+// defaultHashKey returns share.HashKey of the request metadata, or the key of
+// args if it is a HashKeyer, otherwise the key is built from all of the args.
+func defaultHashKey(ctx context.Context, servicePath, serviceMethod string, args any) string {
+	if meta, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		if key, ok := meta[share.HashKey]; ok {
+			return key
+		}
+	}
+
+	if h, ok := args.(HashKeyer); ok {
+		return h.HashKey()
+	}
+
+	return servicePath + "/" + serviceMethod + "/" + toString(args)
+}
+
 type consistentHashSelector struct {
-	servers []string
+	mu      sync.RWMutex
+	ring    *hashRing
+	hashKey HashKeyFunc
 }
 
 func newConsistentHashSelector(servers map[string]string) Selector {
-	ss := make([]string, 0, len(servers))
-	for k := range servers {
-		ss = append(ss, k)
+	return NewConsistentHashSelector(servers, nil)
+}
+
+// NewConsistentHashSelector creates a selector selecting servers on a ketama hash
+// ring by the key returned by hashKey. The default key is used if hashKey is nil.
+// Use it with XClient.SetSelector and SelectByUser to customize the key.
+func NewConsistentHashSelector(servers map[string]string, hashKey HashKeyFunc) Selector {
+	if hashKey == nil {
+		hashKey = defaultHashKey
 	}
 
-	return &consistentHashSelector{servers: ss}
+	return &consistentHashSelector{ring: newHashRing(servers), hashKey: hashKey}
 }
 
 func (s *consistentHashSelector) Select(ctx context.Context, servicePath, serviceMethod string, args any) string {
-	ss := s.servers
+	key := s.hashKey(ctx, servicePath, serviceMethod, args)
 
-	if len(ss) == 0 {
-		return ""
-	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	i := JumpConsistentHash(len(ss), servicePath, serviceMethod, args)
-	return ss[i]
+	return s.ring.get(key)
 }
 
 func (s *consistentHashSelector) UpdateServer(servers map[string]string) {
-	ss := make([]string, 0, len(servers))
-	for k := range servers {
-		ss = append(ss, k)
-	}
+	ring := newHashRing(servers)
 
-	s.servers = ss
+	s.mu.Lock()
+	s.ring = ring
+	s.mu.Unlock()
 }
 
 // weightedICMPSelector selects servers with ping result.
@@ -297,3 +342,222 @@ func (s *weightedICMPSelector) UpdateServer(servers map[string]string) {
 	ss := createICMPWeighted(servers)
 	s.servers = ss
 }
+
+// leastActiveSelector selects the server with the fewest calls in flight,
+// randomly among the servers with the same number of calls.
+type leastActiveSelector struct {
+	mu      sync.Mutex
+	servers []string
+	active  map[string]int
+}
+
+func newLeastActiveSelector(servers map[string]string) Selector {
+	s := &leastActiveSelector{active: make(map[string]int)}
+	s.UpdateServer(servers)
+	return s
+}
+
+func (s *leastActiveSelector) Select(ctx context.Context, servicePath, serviceMethod string, args any) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.servers) == 0 {
+		return ""
+	}
+
+	var least []string
+	min := math.MaxInt
+	for _, server := range s.servers {
+		n := s.active[server]
+		if n < min {
+			least = append(least[:0], server)
+			min = n
+		} else if n == min {
+			least = append(least, server)
+		}
+	}
+
+	return least[fastrand.Uint32n(uint32(len(least)))]
+}
+
+func (s *leastActiveSelector) UpdateServer(servers map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss := make([]string, 0, len(servers))
+	active := make(map[string]int, len(servers))
+	for k := range servers {
+		ss = append(ss, k)
+		active[k] = s.active[k]
+	}
+	s.servers = ss
+	s.active = active
+}
+
+func (s *leastActiveSelector) CallStarted(server string) {
+	s.mu.Lock()
+	// 只统计这个选择器中的服务器
+	if n, ok := s.active[server]; ok {
+		s.active[server] = n + 1
+	}
+	s.mu.Unlock()
+}
+
+func (s *leastActiveSelector) CallFinished(server string, latency time.Duration, err error) {
+	s.mu.Lock()
+	if s.active[server] > 0 {
+		s.active[server]--
+	}
+	s.mu.Unlock()
+}
+
+const (
+	// p2cDecay is the time constant of the EWMA of latencies, older latencies
+	// weigh less as time goes by rather than as calls are made.
+	p2cDecay = 10 * time.Second
+	// p2cFailurePenalty is the latency recorded for a call failed by the network.
+	p2cFailurePenalty = time.Second
+)
+
+type p2cServer struct {
+	server  string
+	active  int
+	latency float64 // 延迟的指数加权移动平均, 单位为纳秒
+	stamp   time.Time
+}
+
+// score returns the score of the server, unobserved is the latency of the
+// servers without any latency observed yet.
+func (s *p2cServer) score(unobserved float64) float64 {
+	latency := s.latency
+	if s.stamp.IsZero() {
+		latency = unobserved
+	}
+	return latency * float64(s.active+1)
+}
+
+func (s *p2cServer) observe(latency time.Duration, now time.Time) {
+	if s.stamp.IsZero() {
+		s.latency = float64(latency)
+	} else {
+		w := math.Exp(-float64(now.Sub(s.stamp)) / float64(p2cDecay))
+		s.latency = s.latency*w + float64(latency)*(1-w)
+	}
+	s.stamp = now
+}
+
+// p2cEWMASelector picks two servers randomly and selects the one with the lower
+// EWMA of latency times the number of calls in flight. Servers without any
+// latency observed yet are scored by the average latency of the other servers,
+// so a new server doesn't get all the calls until its first call returns.
+type p2cEWMASelector struct {
+	mu      sync.Mutex
+	servers []*p2cServer
+	index   map[string]*p2cServer
+
+	// 已经观察到延迟的服务器的数量和延迟之和
+	observed     int
+	totalLatency float64
+}
+
+func newP2CEWMASelector(servers map[string]string) Selector {
+	s := &p2cEWMASelector{index: make(map[string]*p2cServer)}
+	s.UpdateServer(servers)
+	return s
+}
+
+func (s *p2cEWMASelector) Select(ctx context.Context, servicePath, serviceMethod string, args any) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch len(s.servers) {
+	case 0:
+		return ""
+	case 1:
+		return s.servers[0].server
+	}
+
+	n := uint32(len(s.servers))
+	i := fastrand.Uint32n(n)
+	j := fastrand.Uint32n(n - 1)
+	if j >= i {
+		j++
+	}
+
+	// 还没有任何延迟时只比较正在处理的请求数
+	unobserved := 1.0
+	if s.observed > 0 {
+		unobserved = s.totalLatency / float64(s.observed)
+	}
+
+	a, b := s.servers[i], s.servers[j]
+	if b.score(unobserved) < a.score(unobserved) {
+		return b.server
+	}
+
+	return a.server
+}
+
+func (s *p2cEWMASelector) UpdateServer(servers map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss := make([]*p2cServer, 0, len(servers))
+	index := make(map[string]*p2cServer, len(servers))
+	s.observed, s.totalLatency = 0, 0
+	for k := range servers {
+		// 保留已有服务器的统计
+		server := s.index[k]
+		if server == nil {
+			server = &p2cServer{server: k}
+		}
+		ss = append(ss, server)
+		index[k] = server
+		if !server.stamp.IsZero() {
+			s.observed++
+			s.totalLatency += server.latency
+		}
+	}
+
+	s.servers = ss
+	s.index = index
+}
+
+func (s *p2cEWMASelector) CallStarted(server string) {
+	s.mu.Lock()
+	if ps := s.index[server]; ps != nil {
+		ps.active++
+	}
+	s.mu.Unlock()
+}
+
+func (s *p2cEWMASelector) CallFinished(server string, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps := s.index[server]
+	if ps == nil {
+		return
+	}
+	if ps.active > 0 {
+		ps.active--
+	}
+
+	if err != nil {
+		// 被取消的调用的延迟没有意义
+		if !isConnError(err) {
+			if _, ok := err.(ServiceError); !ok {
+				return
+			}
+		} else if latency < p2cFailurePenalty {
+			latency = p2cFailurePenalty
+		}
+	}
+
+	if ps.stamp.IsZero() {
+		s.observed++
+	}
+	old := ps.latency
+	ps.observe(latency, time.Now())
+	s.totalLatency += ps.latency - old
+}
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/marsevilspirit/phobos/server"
+	"github.com/marsevilspirit/phobos/share"
+)
+
+func TestLeastActiveSelector(t *testing.T) {
+	s := newLeastActiveSelector(map[string]string{"a": "", "b": "", "c": ""}).(*leastActiveSelector)
+
+	s.CallStarted("a")
+	s.CallStarted("b")
+	for i := 0; i < 10; i++ {
+		if server := s.Select(context.Background(), "", "", nil); server != "c" {
+			t.Fatalf("expect c to be selected but got %s", server)
+		}
+	}
+
+	s.CallStarted("c")
+	s.CallStarted("c")
+	s.CallFinished("a", time.Millisecond, nil)
+	if server := s.Select(context.Background(), "", "", nil); server != "a" {
+		t.Fatalf("expect a to be selected but got %s", server)
+	}
+
+	s.UpdateServer(map[string]string{"b": "", "c": ""})
+	if server := s.Select(context.Background(), "", "", nil); server != "b" {
+		t.Fatalf("expect b to be selected but got %s", server)
+	}
+	s.CallFinished("a", time.Millisecond, nil)
+	if _, ok := s.active["a"]; ok {
+		t.Fatal("expect the removed server not to be counted")
+	}
+}
+
+func TestP2CEWMASelector(t *testing.T) {
+	s := newP2CEWMASelector(map[string]string{"fast": "", "slow": ""}).(*p2cEWMASelector)
+
+	for _, server := range []string{"fast", "slow"} {
+		s.CallStarted(server)
+	}
+	s.CallFinished("fast", time.Millisecond, nil)
+	s.CallFinished("slow", 100*time.Millisecond, nil)
+	for i := 0; i < 10; i++ {
+		if server := s.Select(context.Background(), "", "", nil); server != "fast" {
+			t.Fatalf("expect fast to be selected but got %s", server)
+		}
+	}
+
+	// 正在处理的请求多了, 得分也会变差
+	for i := 0; i < 200; i++ {
+		s.CallStarted("fast")
+	}
+	if server := s.Select(context.Background(), "", "", nil); server != "slow" {
+		t.Fatalf("expect slow to be selected but got %s", server)
+	}
+
+	// 网络错误按惩罚延迟计算
+	s.CallStarted("slow")
+	s.CallFinished("slow", time.Millisecond, ErrServerUnavailable)
+	if l := s.index["slow"].latency; l <= float64(100*time.Millisecond) {
+		t.Fatalf("expect failure to raise the latency but got %v", time.Duration(l))
+	}
+	s.CallStarted("slow")
+	s.CallFinished("slow", time.Millisecond, context.Canceled)
+	if n := s.index["slow"].active; n != 0 {
+		t.Fatalf("expect no active calls but got %d", n)
+	}
+}
+
+func TestXClient_CallObserver(t *testing.T) {
+	s := server.NewServer()
+	server.RegisterHandler(s, "Arith", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+		time.Sleep(10 * time.Millisecond)
+		return &Reply{C: args.A * args.B}, nil
+	}, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	k := "tcp@" + s.Address().String()
+	xclient := NewXClient("Arith", Failtry, P2CEWMA, NewP2PDiscovery(k, ""), DefaultOption).(*xClient)
+	defer xclient.Close()
+
+	if err := xclient.Call(context.Background(), "Mul", &Args{A: 10, B: 20}, &Reply{}); err != nil {
+		t.Fatalf("failed to call: %v", err)
+	}
+
+	selector := xclient.selector.(*p2cEWMASelector)
+	ps := selector.index[k]
+	if ps.active != 0 || time.Duration(ps.latency) < 10*time.Millisecond {
+		t.Fatalf("expect the call to be observed but got %d active calls and latency %v", ps.active, time.Duration(ps.latency))
+	}
+}
+
+type hashArgs struct {
+	UserID string
+	Extra  int
+}
+
+func (a *hashArgs) HashKey() string {
+	return a.UserID
+}
+
+func TestConsistentHashSelector(t *testing.T) {
+	servers := map[string]string{"a": "", "b": "", "c": "", "d": ""}
+	s := newConsistentHashSelector(servers)
+
+	keys := make([]string, 1000)
+	selected := make(map[string]string, len(keys))
+	for i := range keys {
+		keys[i] = "user" + strconv.Itoa(i)
+		selected[keys[i]] = s.Select(context.Background(), "Arith", "Mul", keys[i])
+	}
+
+	// 服务器没有变化时, 更新不会改变选择结果
+	for i := 0; i < 10; i++ {
+		s.UpdateServer(map[string]string{"d": "", "c": "", "b": "", "a": ""})
+		for _, key := range keys {
+			if server := s.Select(context.Background(), "Arith", "Mul", key); server != selected[key] {
+				t.Fatalf("expect %s for %s but got %s", selected[key], key, server)
+			}
+		}
+	}
+
+	// 增加服务器时, 只有分配给新服务器的 key 会移动
+	s.UpdateServer(map[string]string{"a": "", "b": "", "c": "", "d": "", "e": ""})
+	moved := 0
+	for _, key := range keys {
+		server := s.Select(context.Background(), "Arith", "Mul", key)
+		if server != selected[key] {
+			if server != "e" {
+				t.Fatalf("expect %s to stay or move to e but moved to %s", key, server)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > len(keys)/3 {
+		t.Fatalf("expect about 1/5 of the keys to move but %d moved", moved)
+	}
+
+	// 权重大的服务器分配到更多的 key
+	s.UpdateServer(map[string]string{"a": "weight=4", "b": ""})
+	counts := make(map[string]int)
+	for _, key := range keys {
+		counts[s.Select(context.Background(), "Arith", "Mul", key)]++
+	}
+	if counts["a"] < 2*counts["b"] {
+		t.Fatalf("expect a to get more keys but got %v", counts)
+	}
+
+	// 过大的权重被限制
+	r := newHashRing(map[string]string{"a": "weight=1000000"})
+	if n := len(r.nodes); n != ketamaReplicas*maxHashWeight {
+		t.Fatalf("expect %d virtual nodes but got %d", ketamaReplicas*maxHashWeight, n)
+	}
+}
+
+func TestConsistentHashSelector_HashKey(t *testing.T) {
+	servers := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		servers["server"+strconv.Itoa(i)] = ""
+	}
+	s := newConsistentHashSelector(servers)
+
+	// HashKeyer 只用 UserID 作为 key
+	want := s.Select(context.Background(), "Arith", "Mul", &hashArgs{UserID: "u1"})
+	for i := 0; i < 100; i++ {
+		if server := s.Select(context.Background(), "Arith", "Mul", &hashArgs{UserID: "u1", Extra: i}); server != want {
+			t.Fatalf("expect %s but got %s", want, server)
+		}
+	}
+
+	// 请求元数据中的 key 优先
+	ctx := context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{share.HashKey: "u1"})
+	for i := 0; i < 100; i++ {
+		if server := s.Select(ctx, "Arith", "Div", i); server != want {
+			t.Fatalf("expect %s but got %s", want, server)
+		}
+	}
+
+	s = NewConsistentHashSelector(servers, func(ctx context.Context, servicePath, serviceMethod string, args any) string {
+		return "u1"
+	})
+	if server := s.Select(context.Background(), "Arith", "Mul", &hashArgs{UserID: "u2"}); server != want {
+		t.Fatalf("expect %s but got %s", want, server)
+	}
+}
+
+func TestP2CEWMASelector_NewServer(t *testing.T) {
+	s := newP2CEWMASelector(map[string]string{"a": "", "b": ""}).(*p2cEWMASelector)
+	for _, server := range []string{"a", "b"} {
+		s.CallStarted(server)
+		s.CallFinished(server, 10*time.Millisecond, nil)
+	}
+
+	// 新服务器按平均延迟计分, 有请求在处理之后不再被一直选中
+	s.UpdateServer(map[string]string{"a": "", "b": "", "new": ""})
+	selected := 0
+	for i := 0; i < 100; i++ {
+		server := s.Select(context.Background(), "", "", nil)
+		s.CallStarted(server)
+		if server == "new" {
+			selected++
+		}
+	}
+	if selected > 50 {
+		t.Fatalf("expect the calls to be spread but the new server got %d of 100", selected)
+	}
+}
@@ -0,0 +1,319 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/marsevilspirit/phobos/codec"
+	"github.com/marsevilspirit/phobos/log"
+	"github.com/marsevilspirit/phobos/protocol"
+	"github.com/marsevilspirit/phobos/share"
+	"github.com/marsevilspirit/phobos/util"
+)
+
+var (
+	ErrStreamSendClosed = errors.New("send on closed stream")
+	ErrStreamOverflow   = errors.New("stream peer does not respect flow control")
+)
+
+// Stream is the client side of a stream opened by NewStream.
+//
+// It is safe to call Send and Recv from different goroutines, but it is not
+// safe to call Recv (or Send) from multiple goroutines at the same time.
+type Stream interface {
+	// Context returns the context of the stream.
+	Context() context.Context
+	// Send sends v to the server. It blocks if the server does not read fast enough,
+	// and returns io.EOF if the server has ended the stream.
+	Send(v any) error
+	// Recv receives the next message from the server into v.
+	// It returns io.EOF when the handler returned successfully, or the error
+	// returned by the handler.
+	Recv(v any) error
+	// CloseSend tells the server that no more messages will be sent.
+	CloseSend() error
+	// Close abandons the stream and cancels the context of the server handler
+	// if it is still running. It must be called to release the resources of the stream.
+	Close() error
+}
+
+type streamFrame struct {
+	payload    []byte
+	compressed bool
+}
+
+type clientStream struct {
+	client *Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   func() bool
+
+	seq           uint64
+	serializeType protocol.SerializeType
+	compressType  protocol.CompressType
+	codec         codec.Codec
+
+	window *protocol.StreamWindow
+
+	sendMu     sync.Mutex
+	sendClosed bool
+
+	// recvCh 只由 receive goroutine 写入和关闭
+	recvCh     chan streamFrame
+	recvErr    error
+	recvClosed bool
+	consumed   int
+}
+
+// NewStream opens a stream to the stream method servicePath.serviceMethod.
+// Metadata in ctx with share.ReqMetaDataKey is sent to the server, and the
+// stream is canceled on the server when ctx is done.
+func (client *Client) NewStream(ctx context.Context, servicePath, serviceMethod string) (Stream, error) {
+	client.mu.Lock()
+	if err := client.readyErrLocked(ctx); err != nil {
+		client.mu.Unlock()
+		return nil, err
+	}
+
+	cc := share.Codecs[client.option.SerializeType]
+	if cc == nil {
+		client.mu.Unlock()
+		return nil, ErrUnspportedCodec
+	}
+
+	st := &clientStream{
+		client:        client,
+		seq:           client.seq,
+		serializeType: client.option.SerializeType,
+		compressType:  client.option.CompressType,
+		codec:         cc,
+		window:        protocol.NewStreamWindow(protocol.DefaultStreamWindow),
+		recvCh:        make(chan streamFrame, protocol.DefaultStreamWindow),
+	}
+	st.ctx, st.cancel = context.WithCancel(ctx)
+	client.seq++
+
+	if client.streams == nil {
+		client.streams = make(map[uint64]*clientStream)
+	}
+	client.streams[st.seq] = st
+	st.stop = context.AfterFunc(st.ctx, st.abort)
+	client.mu.Unlock()
+
+	req := protocol.GetPoolMsg()
+	req.SetMessageType(protocol.Request)
+	req.SetSeq(st.seq)
+	req.SetSerializeType(st.serializeType)
+	req.SetStreamFrameType(protocol.StreamOpen)
+	if st.compressType == protocol.Gzip {
+		// 告诉服务端本端可以接收压缩的数据帧
+		req.SetCompressType(protocol.Gzip)
+	}
+	req.ServicePath = servicePath
+	req.ServiceMethod = serviceMethod
+	if meta, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		req.Metadata = meta
+	}
+	req.Metadata = withTimeoutMetadata(ctx, req.Metadata)
+	data := req.Encode()
+	protocol.FreeMsg(req)
+
+	if _, err := client.getConn().Write(data); err != nil {
+		client.removeStream(st.seq)
+		st.cancel()
+		return nil, err
+	}
+
+	// ctx 可能在打开帧发出之前就结束了, 此时之前的取消帧会被服务端忽略
+	if st.ctx.Err() != nil {
+		client.sendCancel(st.seq)
+	}
+
+	return st, nil
+}
+
+func (st *clientStream) Context() context.Context {
+	return st.ctx
+}
+
+func (st *clientStream) Send(v any) error {
+	st.sendMu.Lock()
+	closed := st.sendClosed
+	st.sendMu.Unlock()
+	if closed {
+		return ErrStreamSendClosed
+	}
+
+	data, err := st.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+
+	compressed := false
+	if len(data) > 1024 && st.compressType == protocol.Gzip {
+		data, err = util.Zip(data)
+		if err != nil {
+			return err
+		}
+		compressed = true
+	}
+
+	if err := st.window.Acquire(st.ctx); err != nil {
+		if err == protocol.ErrStreamWindowClosed {
+			// 服务端已经结束了这个流, 结果通过 Recv 获取
+			return io.EOF
+		}
+		return err
+	}
+
+	return st.writeFrame(protocol.StreamData, data, compressed)
+}
+
+func (st *clientStream) CloseSend() error {
+	st.sendMu.Lock()
+	defer st.sendMu.Unlock()
+
+	if st.sendClosed {
+		return nil
+	}
+	st.sendClosed = true
+
+	return st.writeFrame(protocol.StreamEnd, nil, false)
+}
+
+func (st *clientStream) Recv(v any) error {
+	select {
+	case f, ok := <-st.recvCh:
+		if !ok {
+			return st.recvErr
+		}
+
+		st.consumed++
+		if st.consumed >= protocol.DefaultStreamWindow/2 {
+			n := st.consumed
+			st.consumed = 0
+			if err := st.writeFrame(protocol.StreamWindowUpdate, protocol.EncodeWindowUpdate(uint32(n)), false); err != nil {
+				return err
+			}
+		}
+
+		data := f.payload
+		if f.compressed {
+			var err error
+			data, err = util.Unzip(data)
+			if err != nil {
+				return ServiceError("unzip payload: " + err.Error())
+			}
+		}
+
+		return st.codec.Decode(data, v)
+	case <-st.ctx.Done():
+		return st.ctx.Err()
+	}
+}
+
+func (st *clientStream) Close() error {
+	st.cancel()
+	return nil
+}
+
+// abort runs when the context of the stream is done. If the server has not
+// ended the stream yet, it sends a cancellation frame.
+func (st *clientStream) abort() {
+	if st.client.removeStream(st.seq) {
+		st.window.Close()
+		st.client.sendCancel(st.seq)
+	}
+}
+
+func (st *clientStream) writeFrame(t protocol.StreamFrameType, payload []byte, compressed bool) error {
+	req := protocol.GetPoolMsg()
+	req.SetMessageType(protocol.Request)
+	req.SetSeq(st.seq)
+	req.SetSerializeType(st.serializeType)
+	req.SetStreamFrameType(t)
+	if compressed {
+		req.SetCompressType(protocol.Gzip)
+	}
+	req.Payload = payload
+
+	data := req.Encode()
+	protocol.FreeMsg(req)
+
+	_, err := st.client.getConn().Write(data)
+	return err
+}
+
+// push is called by the receive goroutine.
+func (st *clientStream) push(f streamFrame) bool {
+	if st.recvClosed {
+		return true
+	}
+
+	select {
+	case st.recvCh <- f:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeRecv is called by the receive goroutine.
+func (st *clientStream) closeRecv(err error) {
+	if st.recvClosed {
+		return
+	}
+
+	st.recvErr = err
+	st.recvClosed = true
+	close(st.recvCh)
+}
+
+// removeStream removes the stream seq and reports whether it was still open.
+func (client *Client) removeStream(seq uint64) bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if _, ok := client.streams[seq]; !ok {
+		return false
+	}
+	delete(client.streams, seq)
+	return true
+}
+
+// handleStreamFrame handles a stream frame in the receive goroutine.
+func (client *Client) handleStreamFrame(res *protocol.Message) {
+	client.mu.Lock()
+	st := client.streams[res.Seq()]
+	client.mu.Unlock()
+
+	if st == nil {
+		return
+	}
+
+	switch res.StreamFrameType() {
+	case protocol.StreamData:
+		if !st.push(streamFrame{payload: res.Payload, compressed: res.CompressType() == protocol.Gzip}) {
+			st.closeRecv(ErrStreamOverflow)
+			st.abort()
+		}
+	case protocol.StreamWindowUpdate:
+		st.window.Release(int(protocol.DecodeWindowUpdate(res.Payload)))
+	case protocol.StreamEnd:
+		st.finish(io.EOF)
+	case protocol.StreamError:
+		st.finish(ServiceError(res.Metadata[protocol.ServiceError]))
+	default:
+		log.Warnf("phobos: unexpected stream frame %d of stream %d", res.StreamFrameType(), res.Seq())
+	}
+}
+
+// finish ends the stream after the server handler has returned.
+func (st *clientStream) finish(err error) {
+	st.client.removeStream(st.seq)
+	st.closeRecv(err)
+	st.window.Close()
+	st.stop()
+}
@@ -0,0 +1,337 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marsevilspirit/phobos/protocol"
+	"github.com/marsevilspirit/phobos/server"
+	"github.com/marsevilspirit/phobos/serverplugin"
+)
+
+func startStreamServer(t *testing.T) (*server.Server, *Client) {
+	s := server.NewServer()
+	go s.Serve("tcp", "127.0.0.1:0")
+	time.Sleep(500 * time.Millisecond)
+
+	c := NewClient(DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	return s, c
+}
+
+func TestStream_Bidi(t *testing.T) {
+	s, c := startStreamServer(t)
+	defer s.Close()
+	defer c.Close()
+
+	s.RegisterStream("Arith", "MulStream", func(ctx context.Context, stream server.Stream) error {
+		for {
+			args := &Args{}
+			err := stream.Recv(args)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(&Reply{C: args.A * args.B}); err != nil {
+				return err
+			}
+		}
+	}, "")
+
+	stream, err := c.NewStream(context.Background(), "Arith", "MulStream")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer stream.Close()
+
+	// 发送的消息数超过流控窗口, 需要双方都正确地更新窗口
+	n := protocol.DefaultStreamWindow * 4
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := stream.Send(&Args{A: i, B: 2}); err != nil {
+				t.Errorf("failed to send: %v", err)
+				return
+			}
+		}
+		stream.CloseSend()
+	}()
+
+	for i := 0; i < n; i++ {
+		reply := &Reply{}
+		if err := stream.Recv(reply); err != nil {
+			t.Fatalf("failed to recv: %v", err)
+		}
+		if reply.C != i*2 {
+			t.Fatalf("expect %d but got %d", i*2, reply.C)
+		}
+	}
+
+	if err := stream.Recv(&Reply{}); err != io.EOF {
+		t.Fatalf("expect io.EOF but got %v", err)
+	}
+}
+
+func TestStream_FlowControl(t *testing.T) {
+	s, c := startStreamServer(t)
+	defer s.Close()
+	defer c.Close()
+
+	var sent int32
+	s.RegisterStream("Arith", "Count", func(ctx context.Context, stream server.Stream) error {
+		for i := 0; i < protocol.DefaultStreamWindow*4; i++ {
+			if err := stream.Send(&Reply{C: i}); err != nil {
+				return err
+			}
+			atomic.AddInt32(&sent, 1)
+		}
+		return nil
+	}, "")
+
+	stream, err := c.NewStream(context.Background(), "Arith", "Count")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer stream.Close()
+
+	// 客户端不读, 服务端最多只能发出一个窗口的数据
+	time.Sleep(200 * time.Millisecond)
+	if n := atomic.LoadInt32(&sent); n != protocol.DefaultStreamWindow {
+		t.Fatalf("expect server to send %d frames but sent %d", protocol.DefaultStreamWindow, n)
+	}
+
+	for i := 0; ; i++ {
+		reply := &Reply{}
+		err := stream.Recv(reply)
+		if err == io.EOF {
+			if i != protocol.DefaultStreamWindow*4 {
+				t.Fatalf("expect %d replies but got %d", protocol.DefaultStreamWindow*4, i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to recv: %v", err)
+		}
+	}
+}
+
+func TestStream_ErrorAndCancel(t *testing.T) {
+	s, c := startStreamServer(t)
+	defer s.Close()
+	defer c.Close()
+
+	s.RegisterStream("Arith", "Fail", func(ctx context.Context, stream server.Stream) error {
+		return errors.New("bad stream")
+	}, "")
+
+	canceled := make(chan struct{})
+	s.RegisterStream("Arith", "Wait", func(ctx context.Context, stream server.Stream) error {
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	}, "")
+
+	stream, err := c.NewStream(context.Background(), "Arith", "Fail")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	err = stream.Recv(&Reply{})
+	if err == nil || err.Error() != "bad stream" {
+		t.Fatalf("expect error 'bad stream' but got %v", err)
+	}
+	stream.Close()
+
+	stream, err = c.NewStream(context.Background(), "Arith", "NotExist")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	if err = stream.Recv(&Reply{}); err == nil {
+		t.Fatal("expect an error but got nil")
+	}
+	stream.Close()
+
+	stream, err = c.NewStream(context.Background(), "Arith", "Wait")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	stream.Close()
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("handler context was not canceled")
+	}
+}
+
+func TestStream_ConnClosedWhileSending(t *testing.T) {
+	s, c := startStreamServer(t)
+	defer s.Close()
+
+	returned := make(chan error, 1)
+	s.RegisterStream("Arith", "Flood", func(ctx context.Context, stream server.Stream) error {
+		for i := 0; ; i++ {
+			if err := stream.Send(&Reply{C: i}); err != nil {
+				returned <- err
+				return err
+			}
+		}
+	}, "")
+
+	if _, err := c.NewStream(context.Background(), "Arith", "Flood"); err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	// 客户端不读, 服务端阻塞在 Send 等待窗口
+	time.Sleep(200 * time.Millisecond)
+	c.Close()
+
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("handler blocked in Send did not return after the connection was closed")
+	}
+}
+
+func TestStream_MaxConcurrentPerConn(t *testing.T) {
+	s := server.NewServer(server.WithMaxConcurrentRequestsPerConn(1))
+	s.RegisterStream("Arith", "Wait", func(ctx context.Context, stream server.Stream) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, "")
+	server.RegisterHandler(s, "Arith", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+		return &Reply{C: args.A * args.B}, nil
+	}, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	c := NewClient(DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	stream, err := c.NewStream(context.Background(), "Arith", "Wait")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// 流占用了连接唯一的名额
+	other, err := c.NewStream(context.Background(), "Arith", "Wait")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	if err := other.Recv(&Reply{}); err == nil || err.Error() != server.ErrServerOverloaded.Error() {
+		t.Fatalf("expect the stream to be rejected but got %v", err)
+	}
+	other.Close()
+	if err := c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, &Reply{}); err == nil || err.Error() != server.ErrServerOverloaded.Error() {
+		t.Fatalf("expect the request to be rejected but got %v", err)
+	}
+
+	stream.Close()
+	time.Sleep(100 * time.Millisecond)
+	reply := &Reply{}
+	if err := c.Call(context.Background(), "Arith", "Mul", &Args{A: 10, B: 20}, reply); err != nil || reply.C != 200 {
+		t.Fatalf("expect the request to succeed after the stream ends, got %d, %v", reply.C, err)
+	}
+}
+
+func TestStream_PreHandleRequestPlugin(t *testing.T) {
+	s := server.NewServer()
+	s.Plugins.Add(serverplugin.NewLimitPlugin().SetMethodLimit("Arith", "Wait", serverplugin.Limit{MaxConcurrent: 1}))
+	s.RegisterStream("Arith", "Wait", func(ctx context.Context, stream server.Stream) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	c := NewClient(DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	stream, err := c.NewStream(context.Background(), "Arith", "Wait")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	other, err := c.NewStream(context.Background(), "Arith", "Wait")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	if err := other.Recv(&Reply{}); err == nil || err.Error() != serverplugin.ErrTooManyConcurrentRequests.Error() {
+		t.Fatalf("expect the stream to be limited but got %v", err)
+	}
+	other.Close()
+
+	// 流结束后释放限流器
+	stream.Close()
+	time.Sleep(100 * time.Millisecond)
+	stream, err = c.NewStream(context.Background(), "Arith", "Wait")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer stream.Close()
+	recvErr := make(chan error, 1)
+	go func() { recvErr <- stream.Recv(&Reply{}) }()
+	select {
+	case err := <-recvErr:
+		t.Fatalf("expect the stream to be open after the first one ends, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStream_AdaptiveLimit(t *testing.T) {
+	s := server.NewServer(server.WithAdaptiveLimit("Arith", server.AdaptiveLimit{InitialLimit: 1, MaxLimit: 1}))
+	s.RegisterStream("Arith", "Wait", func(ctx context.Context, stream server.Stream) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	c := NewClient(DefaultOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	stream, err := c.NewStream(context.Background(), "Arith", "Wait")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := s.AdaptiveLimits()["Arith"].Inflight; n != 1 {
+		t.Fatalf("expect the stream to be in flight but got %d", n)
+	}
+
+	other, err := c.NewStream(context.Background(), "Arith", "Wait")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	if err := other.Recv(&Reply{}); err == nil || err.Error() != server.ErrServerOverloaded.Error() {
+		t.Fatalf("expect the stream to be rejected but got %v", err)
+	}
+	other.Close()
+
+	stream.Close()
+	time.Sleep(100 * time.Millisecond)
+	if n := s.AdaptiveLimits()["Arith"].Inflight; n != 0 {
+		t.Fatalf("expect the limiter to be released after the stream ends, got %d", n)
+	}
+}
@@ -3,12 +3,13 @@ package client
 import (
 	"context"
 	"errors"
-	"reflect"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/marsevilspirit/phobos/breaker"
 	ex "github.com/marsevilspirit/phobos/errors"
+	"github.com/marsevilspirit/phobos/log"
 	"github.com/marsevilspirit/phobos/protocol"
 	"github.com/marsevilspirit/phobos/share"
 )
@@ -26,9 +27,20 @@ type XClient interface {
 	Auth(auth string)
 	Go(ctx context.Context, serviceMethod string, args, reply any, done chan *Call) (*Call, error)
 	Call(ctx context.Context, serviceMethod string, args, reply any) error
+	// Async calls serviceMethod asynchronously like Call, and returns the Future of the call.
+	Async(ctx context.Context, serviceMethod string, args, reply any) Future
 	Broadcast(ctx context.Context, serviceMethod string, args, reply any) error
 	Fork(ctx context.Context, serviceMethod string, args, reply any) error
+	// BroadcastAll calls all the servers, and succeeds if quorum of them succeed.
+	BroadcastAll(ctx context.Context, serviceMethod string, args, reply any, quorum int) ([]*CallResult, error)
+	// ForkAll calls all the servers, and returns once quorum of them succeed.
+	ForkAll(ctx context.Context, serviceMethod string, args, reply any, quorum int) ([]*CallResult, error)
 	SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error)
+	NewStream(ctx context.Context, serviceMethod string) (Stream, error)
+	// SetRouteRules sets the rules filtering the servers of the calls.
+	SetRouteRules(rules ...RouteRule)
+	// BreakerStates returns the states of the breakers of the servers.
+	BreakerStates() map[string]breaker.State
 	Close() error
 }
 
@@ -52,9 +64,9 @@ type ServiceDiscovery interface {
 
 // xClient 结构体实现 XClient 接口
 type xClient struct {
-	failMode     FailMode             // 失败处理模式
-	selectMode   SelectMode           // 选择处理模式
-	cachedClient map[string]RPCClient // 缓存的客户端连接
+	failMode   FailMode             // 失败处理模式
+	selectMode SelectMode           // 选择处理模式
+	pools      map[string]*connPool // 每个服务器的连接池
 
 	mu        sync.RWMutex      // 读写锁，用于保护共享资源的并发访问
 	servers   map[string]string // 当前已知的服务器地址
@@ -69,26 +81,53 @@ type xClient struct {
 
 	auth string
 
-	// Latitude  float64
-	// Longitude float64
+	latitude  float64
+	longitude float64
 
 	Plugins PluginContainer
 
 	ch chan []*KVPair
 
 	serverMessageChan chan<- *protocol.Message
+
+	// 健康检查失败的服务器, 不参与选择
+	unhealthy  map[string]bool
+	healthStop chan struct{}
+
+	// 由 Option.BreakerFactory 为每个服务器创建的熔断器
+	breakersMu sync.Mutex
+	breakers   map[breakerID]Breaker
+
+	retryBudget *retryBudget
+
+	// 每个方法最近的调用延迟, 用于计算备份请求的等待时间
+	latencies sync.Map
+
+	// 路由规则, 以及在路由后的服务器中选择的选择器
+	routeRules []RouteRule
+	// 服务器或路由规则变化时递增, 由 c.mu 保护
+	routeGen          uint64
+	routeSelectorsMu  sync.Mutex
+	routeSelectorsGen uint64
+	routeSelectors    map[uint64]*routedSelector
+
+	// 被异常检测弹出的服务器, 不参与选择
+	outlier *outlierDetector
+	ejected map[string]bool
 }
 
 // NewXClient 工厂函数，用于创建 xClient 实例
 func NewXClient(servicePath string, failMode FailMode, selectMode SelectMode, discovery ServiceDiscovery, option Option) XClient {
 	// 初始化 xClient 结构体
 	client := &xClient{
-		failMode:     failMode,
-		selectMode:   selectMode,
-		discovery:    discovery,
-		servicePath:  servicePath,
-		cachedClient: make(map[string]RPCClient),
-		option:       option,
+		failMode:    failMode,
+		selectMode:  selectMode,
+		discovery:   discovery,
+		servicePath: servicePath,
+		pools:       make(map[string]*connPool),
+		option:      option,
+		retryBudget: newRetryBudget(option.RetryBudget),
+		outlier:     newOutlierDetector(option.OutlierDetection),
 	}
 
 	// 更新服务列表
@@ -110,6 +149,8 @@ func NewXClient(servicePath string, failMode FailMode, selectMode SelectMode, di
 		go client.watch(ch)
 	}
 
+	client.startHealthCheck()
+
 	return client
 }
 
@@ -119,9 +160,11 @@ func NewBidirectionalXClient(servicePath string, failMode FailMode, selectMode S
 		selectMode:        selectMode,
 		discovery:         discovery,
 		servicePath:       servicePath,
-		cachedClient:      make(map[string]RPCClient),
+		pools:             make(map[string]*connPool),
 		option:            option,
 		serverMessageChan: serverMessageChan,
+		retryBudget:       newRetryBudget(option.RetryBudget),
+		outlier:           newOutlierDetector(option.OutlierDetection),
 	}
 
 	servers := make(map[string]string)
@@ -142,12 +185,14 @@ func NewBidirectionalXClient(servicePath string, failMode FailMode, selectMode S
 		go client.watch(ch)
 	}
 
+	client.startHealthCheck()
+
 	return client
 }
 
 func (c *xClient) SetSelector(s Selector) {
 	c.mu.RLock()
-	s.UpdateServer(c.servers)
+	s.UpdateServer(c.availableServersLocked())
 	c.mu.RUnlock()
 
 	c.selector = s
@@ -160,8 +205,12 @@ func (c *xClient) SetPlugins(plugins PluginContainer) {
 // ConfigGeoSelector sets location of client's latitude and longitude,
 // and use newGeoSelector.
 func (c *xClient) ConfigGeoSelector(latitude, longitude float64) {
-	c.selector = newGeoSelector(c.servers, latitude, longitude)
+	c.mu.Lock()
+	c.selector = newGeoSelector(c.availableServersLocked(), latitude, longitude)
+	c.latitude, c.longitude = latitude, longitude
 	c.selectMode = Closest
+	c.routeGen++
+	c.mu.Unlock()
 }
 
 func (c *xClient) Auth(auth string) {
@@ -177,74 +226,113 @@ func (c *xClient) watch(ch chan []*KVPair) {
 		}
 		c.mu.Lock()
 		c.servers = servers
+		for k := range c.unhealthy {
+			if _, ok := servers[k]; !ok {
+				delete(c.unhealthy, k)
+			}
+		}
+		for k := range c.ejected {
+			if _, ok := servers[k]; !ok {
+				delete(c.ejected, k)
+			}
+		}
 
 		if c.selector != nil {
-			c.selector.UpdateServer(servers)
+			c.selector.UpdateServer(c.availableServersLocked())
 		}
+		c.routeGen++
 
 		c.mu.Unlock()
+
+		c.removeBreakers(servers)
+		if c.outlier != nil {
+			c.outlier.remove(servers)
+		}
 	}
 }
 
 // selectClient 方法，用于根据选择模式选择客户端
 func (c *xClient) selectClient(ctx context.Context, servicePath, serviceMethod string, args any) (string, RPCClient, error) {
-	k := c.selector.Select(ctx, servicePath, serviceMethod, args)
+	// 服务发现和健康检查会在持有写锁时更新 selector
+	c.mu.RLock()
+	// 跳过熔断器打开的服务器
+	k := c.selectServerLocked(ctx, servicePath, serviceMethod, args, func(k string) bool {
+		return c.isBreakerOpen(k, serviceMethod)
+	})
+	c.mu.RUnlock()
 	if k == "" {
 		return "", nil, ErrXClientNoServer
 	}
+	if c.isBreakerOpen(k, serviceMethod) {
+		return "", nil, breaker.ErrOpenState
+	}
 
 	client, err := c.getCachedClient(k)
 
 	return k, client, err
 }
 
-// getCachedClient 方法，根据服务器键获取缓存的客户端连接
+// getCachedClient 方法，从服务器的连接池中获取客户端连接
 func (c *xClient) getCachedClient(k string) (RPCClient, error) {
 	c.mu.RLock()
-	client := c.cachedClient[k]
-	if client != nil {
-		if !client.IsClosing() && !client.IsShutdown() {
-			c.mu.RUnlock()
-			return client, nil
-		}
-	}
+	pool := c.pools[k]
 	c.mu.RUnlock()
 
-	// 双检查，确保线程安全
-	c.mu.Lock()
-	client = c.cachedClient[k]
-	if client == nil {
-		network, addr := splitNetworkAndAddress(k)
-		client = &Client{
-			option:  c.option,
-			Plugins: c.Plugins,
-		}
-		err := client.Connect(network, addr)
-		if err != nil {
-			c.mu.Unlock()
-			return nil, err
+	if pool == nil {
+		// 双检查，确保线程安全
+		c.mu.Lock()
+		pool = c.pools[k]
+		if pool == nil {
+			pool = newConnPool(c.option.ConnectionsPerServer, c.option.ConnPoolMode, func() (RPCClient, error) {
+				return c.newClient(k)
+			})
+			c.pools[k] = pool
 		}
+		c.mu.Unlock()
+	}
 
-		client.RegisterServerMessageChan(c.serverMessageChan)
+	return pool.get()
+}
 
-		c.cachedClient[k] = client
+// newClient connects to the server k.
+func (c *xClient) newClient(k string) (RPCClient, error) {
+	network, addr := splitNetworkAndAddress(k)
+	option := c.option
+	if option.Breaker == nil {
+		// 熔断器由 xClient 按服务器管理
+		option.BreakerFactory = nil
 	}
-	c.mu.Unlock()
+	client := &Client{
+		option:  option,
+		Plugins: c.Plugins,
+	}
+	if err := client.Connect(network, addr); err != nil && !option.Reconnect {
+		return nil, err
+	}
+
+	client.RegisterServerMessageChan(c.serverMessageChan)
 
 	return client, nil
 }
 
+// removeClient closes the broken client of server k, the other connections to k are kept.
+// The clients reconnecting by themselves are kept.
 func (c *xClient) removeClient(k string, client RPCClient) {
-	c.mu.Lock()
-	if c.cachedClient[k] == client {
-		delete(c.cachedClient, k)
+	if client == nil || c.option.Reconnect {
+		return
 	}
-	c.mu.Unlock()
 
-	if client != nil {
-		client.UnregisterServerMessageChan()
-		client.Close()
+	c.mu.RLock()
+	pool := c.pools[k]
+	c.mu.RUnlock()
+
+	if pool != nil {
+		pool.remove(client)
+		return
 	}
+
+	client.UnregisterServerMessageChan()
+	client.Close()
 }
 
 // splitNetworkAndAddress 方法，用于分割服务器地址
@@ -257,42 +345,95 @@ func splitNetworkAndAddress(server string) (string, string) {
 	return ss[0], ss[1]
 }
 
-func (c *xClient) wrapCall(ctx context.Context, client RPCClient, serviceMethod string, args any, reply any) error {
+func (c *xClient) wrapCall(ctx context.Context, k string, client RPCClient, serviceMethod string, args any, reply any) error {
 	if client == nil {
+		// 连不上服务器也是一次失败
+		if b := c.getBreaker(k, serviceMethod); b != nil && k != "" {
+			b.Execute(func() (any, error) { return nil, ErrServerUnavailable })
+		}
+		c.observeOutlier(k, ErrServerUnavailable)
 		return ErrServerUnavailable
 	}
 
-	c.Plugins.DoPreCall(ctx, c.servicePath, serviceMethod, args)
-	err := client.Call(ctx, c.servicePath, serviceMethod, args, reply)
-	c.Plugins.DoPostCall(ctx, c.servicePath, serviceMethod, args, reply, err)
+	if len(c.option.AttemptInterceptors) == 0 {
+		return c.invoke(ctx, k, client, serviceMethod, args, reply)
+	}
+
+	return chainInterceptors(c.option.AttemptInterceptors, func(ctx context.Context, serviceMethod string, args, reply any) error {
+		return c.invoke(ctx, k, client, serviceMethod, args, reply)
+	})(ctx, serviceMethod, args, reply)
+}
+
+// invoke calls server k by client with the plugins, observers and breaker.
+func (c *xClient) invoke(ctx context.Context, k string, client RPCClient, serviceMethod string, args any, reply any) error {
+	if err := c.Plugins.DoPreCall(ctx, c.servicePath, serviceMethod, args); err != nil {
+		return err
+	}
+
+	var err error
+	if observers := c.callObservers(); len(observers) > 0 {
+		for _, o := range observers {
+			o.CallStarted(k)
+		}
+		start := time.Now()
+		defer func() {
+			for _, o := range observers {
+				o.CallFinished(k, time.Since(start), err)
+			}
+		}()
+	}
+	if b := c.getBreaker(k, serviceMethod); b != nil {
+		_, err = b.Execute(func() (any, error) {
+			return nil, client.Call(ctx, c.servicePath, serviceMethod, args, reply)
+		})
+	} else {
+		err = client.Call(ctx, c.servicePath, serviceMethod, args, reply)
+	}
+	c.observeOutlier(k, err)
+	// 插件返回的错误替换调用的错误
+	if perr := c.Plugins.DoPostCall(ctx, c.servicePath, serviceMethod, args, reply, err); perr != nil {
+		err = perr
+	}
 	return err
 }
 
-// Go 方法实现异步调用 RPC
+// Go 方法实现异步调用 RPC, 与 Call 一样经过拦截器、插件和失败模式
 func (c *xClient) Go(ctx context.Context, serviceMethod string, args, reply any, done chan *Call) (*Call, error) {
 	if c.isShutdown {
 		return nil, ErrXClientShutdown
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			return nil, errors.New("must set ReqMetaDataKey in context")
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
+	call := &Call{
+		ServicePath:   c.servicePath,
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
 	}
-
-	_, client, err := c.selectClient(ctx, c.servicePath, serviceMethod, args)
-	if err != nil {
-		return nil, err
+	if meta, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		call.Metadata = meta
 	}
+	if call.Done == nil {
+		call.Done = make(chan *Call, 10)
+	} else if cap(call.Done) == 0 {
+		log.Panic("rpc: done channel is unbuffered")
+	}
+
+	go func() {
+		call.Error = c.Call(ctx, serviceMethod, args, reply)
+		call.done()
+	}()
 
-	return client.Go(ctx, c.servicePath, serviceMethod, args, reply, done), nil
+	return call, nil
 }
 
-// Call 方法实现同步调用 RPC，通过调用 Go 方法并等待结果
+// Call 方法实现同步调用 RPC
 func (c *xClient) Call(ctx context.Context, serviceMethod string, args, reply any) error {
+	return c.intercept(ctx, serviceMethod, args, reply, c.call)
+}
+
+// call is Call without the interceptors.
+func (c *xClient) call(ctx context.Context, serviceMethod string, args, reply any) error {
 	if c.isShutdown {
 		return ErrXClientShutdown
 	}
@@ -306,8 +447,6 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args, reply an
 		m[share.AuthKey] = c.auth
 	}
 
-	var err error
-
 	k, client, err := c.selectClient(ctx, c.servicePath, serviceMethod, args)
 	if err != nil {
 		if c.failMode == Failfast {
@@ -320,45 +459,32 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args, reply an
 	}
 
 	switch c.failMode {
-	case Failtry:
-		retries := c.option.Retries
-		for retries > 0 {
-			retries--
-			err = c.wrapCall(ctx, client, serviceMethod, args, reply)
+	case Failtry, Failover:
+		c.retryBudget.call()
+		for attempt := 1; ; attempt++ {
+			err = c.wrapCall(ctx, k, client, serviceMethod, args, reply)
 			if err == nil {
 				return nil
 			}
-			if _, ok := err.(ServiceError); ok {
-				return err
-			}
-			c.removeClient(k, client)
-			client, _ = c.getCachedClient(k)
-		}
-		return err
-	case Failover:
-		retries := c.option.Retries
-		for retries > 0 {
-			retries--
-			err = c.wrapCall(ctx, client, serviceMethod, args, reply)
-			if err == nil {
-				return nil
+			if isConnError(err) {
+				c.removeClient(k, client)
 			}
-			if _, ok := err.(ServiceError); ok {
+			if !c.shouldRetry(ctx, attempt, err) {
 				return err
 			}
 
-			c.removeClient(k, client)
-
-			k, client, _ = c.selectClient(ctx, c.servicePath, serviceMethod, args)
+			if c.failMode == Failtry {
+				client, _ = c.getCachedClient(k)
+			} else {
+				k, client, _ = c.selectClient(ctx, c.servicePath, serviceMethod, args)
+			}
 		}
-
-		return err
+	case Failbackup:
+		return c.callBackup(ctx, k, client, serviceMethod, args, reply)
 	default: // Failfast
-		err = c.wrapCall(ctx, client, serviceMethod, args, reply)
-		if err != nil {
-			if _, ok := err.(ServiceError); !ok {
-				c.removeClient(k, client)
-			}
+		err = c.wrapCall(ctx, k, client, serviceMethod, args, reply)
+		if err != nil && isConnError(err) {
+			c.removeClient(k, client)
 		}
 
 		return err
@@ -366,6 +492,19 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args, reply an
 }
 
 func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error) {
+	var m map[string]string
+	var payload []byte
+	err := c.intercept(ctx, r.ServiceMethod, r, nil, func(ctx context.Context, serviceMethod string, args, reply any) error {
+		var err error
+		m, payload, err = c.sendRaw(ctx, rawMessage(args, r))
+		return err
+	})
+
+	return m, payload, err
+}
+
+// sendRaw is SendRaw without the interceptors.
+func (c *xClient) sendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error) {
 	if c.isShutdown {
 		return nil, nil, ErrXClientShutdown
 	}
@@ -391,196 +530,133 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 	}
 
 	switch c.failMode {
-	case Failtry:
-		retries := c.option.Retries
-		for retries > 0 {
-			retries--
-			m, payload, err := client.SendRaw(ctx, r)
-			if err == nil {
-				return m, payload, nil
+	case Failtry, Failover:
+		c.retryBudget.call()
+		for attempt := 1; ; attempt++ {
+			if client == nil {
+				err = ErrServerUnavailable
+			} else {
+				var m map[string]string
+				var payload []byte
+				m, payload, err = c.sendRawAttempt(ctx, client, r)
+				if err == nil {
+					return m, payload, nil
+				}
+				if isConnError(err) {
+					c.removeClient(k, client)
+				}
 			}
-
-			if _, ok := err.(ServiceError); ok {
+			if !c.shouldRetry(ctx, attempt, err) {
 				return nil, nil, err
 			}
 
-			c.removeClient(k, client)
-			client, _ = c.getCachedClient(k)
-		}
-		return nil, nil, err
-	case Failover:
-		retries := c.option.Retries
-		for retries > 0 {
-			retries--
-			m, payload, err := client.SendRaw(ctx, r)
-			if err == nil {
-				return m, payload, nil
-			}
-			if _, ok := err.(ServiceError); ok {
-				return nil, nil, err
+			if c.failMode == Failtry {
+				client, _ = c.getCachedClient(k)
+			} else {
+				k, client, _ = c.selectClient(ctx, c.servicePath, r.ServiceMethod, r.Payload)
 			}
-
-			c.removeClient(k, client)
-			k, client, _ = c.selectClient(ctx, c.servicePath, r.ServiceMethod, r.Payload)
 		}
-		return nil, nil, err
+	case Failbackup:
+		// 原始消息不发送备份请求, 按 Failfast 处理
+		if client == nil {
+			return nil, nil, err
+		}
+		fallthrough
 	default: // Failfast
-		m, payload, err := client.SendRaw(ctx, r)
+		m, payload, err := c.sendRawAttempt(ctx, client, r)
 
-		if err != nil {
-			if _, ok := err.(ServiceError); !ok {
-				c.removeClient(k, client)
-			}
+		if err != nil && isConnError(err) {
+			c.removeClient(k, client)
 		}
 
 		return m, payload, err
 	}
 }
 
-func (c *xClient) Broadcast(ctx context.Context, serviceMethod string, args, reply any) error {
-	if c.isShutdown {
-		return ErrXClientShutdown
+// sendRawAttempt sends r by client through Option.AttemptInterceptors.
+func (c *xClient) sendRawAttempt(ctx context.Context, client RPCClient, r *protocol.Message) (map[string]string, []byte, error) {
+	if len(c.option.AttemptInterceptors) == 0 {
+		return client.SendRaw(ctx, r)
 	}
 
-	if c.auth != "" {
-		metadata := ctx.Value(share.ReqMetaDataKey)
-		if metadata == nil {
-			return errors.New("must set ReqMetaDataKey in context")
-		}
-		m := metadata.(map[string]string)
-		m[share.AuthKey] = c.auth
-	}
-
-	var clients []RPCClient
-
-	c.mu.RLock()
-	for k := range c.servers {
-		client, err := c.getCachedClient(k)
-		if err != nil {
-			c.mu.RUnlock()
-			return err
-		}
-		clients = append(clients, client)
-	}
-	c.mu.RUnlock()
-
-	if len(clients) == 0 {
-		return ErrXClientNoServer
-	}
-
-	var err error
-	l := len(clients)
-	done := make(chan bool, l)
-	for _, client := range clients {
-		client := client
-		go func() {
-			err = c.wrapCall(ctx, client, serviceMethod, args, reply)
-			done <- (err == nil)
-		}()
-	}
-
-	timeout := time.After(time.Minute)
-
-check:
-	for {
-		select {
-		case result := <-done:
-			l--
-			if l == 0 || !result {
-				break check
-			}
-		case <-timeout:
-			break check
-		}
-	}
+	var m map[string]string
+	var payload []byte
+	err := chainInterceptors(c.option.AttemptInterceptors, func(ctx context.Context, serviceMethod string, args, reply any) error {
+		var err error
+		m, payload, err = client.SendRaw(ctx, rawMessage(args, r))
+		return err
+	})(ctx, r.ServiceMethod, r, nil)
 
-	return err
+	return m, payload, err
 }
 
-func (c *xClient) Fork(ctx context.Context, serviceMethod string, args, reply any) error {
+// NewStream opens a stream to serviceMethod on a server chosen by the selector.
+func (c *xClient) NewStream(ctx context.Context, serviceMethod string) (Stream, error) {
 	if c.isShutdown {
-		return ErrXClientShutdown
+		return nil, ErrXClientShutdown
 	}
 
 	if c.auth != "" {
 		metadata := ctx.Value(share.ReqMetaDataKey)
 		if metadata == nil {
-			return errors.New("must set ReqMetaDataKey in context")
+			return nil, errors.New("must set ReqMetaDataKey in context")
 		}
 		m := metadata.(map[string]string)
 		m[share.AuthKey] = c.auth
 	}
 
-	var clients []RPCClient
-
-	c.mu.RLock()
-	for k := range c.servers {
-		client, err := c.getCachedClient(k)
-		if err != nil {
-			c.mu.RUnlock()
-			return err
-		}
-
-		clients = append(clients, client)
-	}
-	c.mu.RUnlock()
-
-	if len(clients) == 0 {
-		return ErrXClientNoServer
+	k, client, err := c.selectClient(ctx, c.servicePath, serviceMethod, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	var err error
-	l := len(clients)
-	done := make(chan bool, l)
-	for _, client := range clients {
-		client := client
-		go func() {
-			// 代码中只有在调用成功（err == nil）时才会更新原始的 reply 这样可以确保只有成功的调用结果才会被保存
-			clonedReply := reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
-			err = c.wrapCall(ctx, client, serviceMethod, args, clonedReply)
-			done <- (err == nil)
-			if err == nil {
-				reflect.ValueOf(reply).Set(reflect.ValueOf(clonedReply))
-			}
-			return
-		}()
+	stream, err := client.NewStream(ctx, c.servicePath, serviceMethod)
+	if err != nil {
+		c.removeClient(k, client)
+		return nil, err
 	}
 
-	timeout := time.After(time.Minute)
+	return stream, nil
+}
 
-check:
-	for {
-		select {
-		case result := <-done:
-			l--
-			if result {
-				return nil
-			}
-			if l == 0 { // all returns or some one returns an error
-				break check
+// Broadcast calls all the servers and returns an error if any of them fails.
+// reply is set to the first successful reply.
+func (c *xClient) Broadcast(ctx context.Context, serviceMethod string, args, reply any) error {
+	return c.intercept(ctx, serviceMethod, args, reply, func(ctx context.Context, serviceMethod string, args, reply any) error {
+		results, err := c.callAll(ctx, serviceMethod, args, reply, 0, false)
+		for _, r := range results {
+			if r.Error != nil {
+				return r.Error
 			}
+		}
 
-		case <-timeout:
-			break check
+		return err
+	})
+}
+
+// Fork calls all the servers and returns once any of them replies successfully,
+// the other calls are canceled.
+func (c *xClient) Fork(ctx context.Context, serviceMethod string, args, reply any) error {
+	return c.intercept(ctx, serviceMethod, args, reply, func(ctx context.Context, serviceMethod string, args, reply any) error {
+		results, err := c.callAll(ctx, serviceMethod, args, reply, 1, true)
+		if err == nil || len(results) == 0 {
+			return err
 		}
-	}
 
-	return err
+		return results[len(results)-1].Error
+	})
 }
 
 // Close 方法关闭客户端，释放资源
 func (c *xClient) Close() error {
 	c.isShutdown = true
+	c.stopHealthCheck()
 
 	var errs []error
 	c.mu.Lock()
-	for k, v := range c.cachedClient {
-		e := v.Close()
-		if e != nil {
-			errs = append(errs, e)
-		}
-
-		delete(c.cachedClient, k)
+	for k, pool := range c.pools {
+		errs = append(errs, pool.close()...)
+		delete(c.pools, k)
 	}
 	c.mu.Unlock()
 
@@ -2,10 +2,15 @@ package client
 
 import (
 	"context"
+	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/marsevilspirit/phobos/breaker"
+	"github.com/marsevilspirit/phobos/protocol"
 	"github.com/marsevilspirit/phobos/server"
+	"github.com/marsevilspirit/phobos/share"
 )
 
 func TestXClient_IT(t *testing.T) {
@@ -38,3 +43,155 @@ func TestXClient_IT(t *testing.T) {
 		t.Fatalf("expect 200 but got %d", reply.C)
 	}
 }
+
+func TestInvoke(t *testing.T) {
+	s := server.Server{}
+	server.RegisterHandler(&s, "Arith", "Add", func(ctx context.Context, args *Args) (*Reply, error) {
+		return &Reply{C: args.A + args.B}, nil
+	}, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(500 * time.Millisecond)
+
+	d := NewP2PDiscovery("tcp@"+s.Address().String(), "")
+	xclient := NewXClient("Arith", Failtry, RandomSelect, d, DefaultOption)
+	defer xclient.Close()
+
+	reply, err := Invoke[Args, Reply](context.Background(), xclient, "Add", &Args{A: 10, B: 20})
+	if err != nil {
+		t.Fatalf("failed to invoke: %v", err)
+	}
+	if reply.C != 30 {
+		t.Fatalf("expect 30 but got %d", reply.C)
+	}
+}
+
+func TestXClient_HealthCheck(t *testing.T) {
+	var calls [2]int32
+	var servers [2]*server.Server
+	var pairs []*KVPair
+	for i := range servers {
+		i := i
+		s := server.NewServer()
+		server.RegisterHandler(s, "Arith", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+			atomic.AddInt32(&calls[i], 1)
+			return &Reply{C: args.A * args.B}, nil
+		}, "")
+		go s.Serve("tcp", "127.0.0.1:0")
+		defer s.Close()
+		servers[i] = s
+	}
+	time.Sleep(500 * time.Millisecond)
+	for _, s := range servers {
+		pairs = append(pairs, &KVPair{Key: "tcp@" + s.Address().String()})
+	}
+
+	opt := DefaultOption
+	opt.HealthCheckInterval = 50 * time.Millisecond
+	xclient := NewXClient("Arith", Failfast, RoundRobin, NewMultipleServersDiscovery(pairs), opt)
+	defer xclient.Close()
+
+	callN := func(n int) {
+		atomic.StoreInt32(&calls[0], 0)
+		atomic.StoreInt32(&calls[1], 0)
+		for i := 0; i < n; i++ {
+			if err := xclient.Call(context.Background(), "Mul", &Args{A: 1, B: 2}, &Reply{}); err != nil {
+				t.Fatalf("failed to call: %v", err)
+			}
+		}
+	}
+
+	servers[0].SetServingStatus("Arith", share.NotServing)
+	time.Sleep(200 * time.Millisecond)
+	callN(10)
+	if atomic.LoadInt32(&calls[0]) != 0 || atomic.LoadInt32(&calls[1]) != 10 {
+		t.Fatalf("expect all calls to the healthy server but got %v", calls)
+	}
+
+	servers[0].SetServingStatus("Arith", share.Serving)
+	time.Sleep(200 * time.Millisecond)
+	callN(10)
+	if atomic.LoadInt32(&calls[0]) == 0 {
+		t.Fatal("expect the recovered server to be selected again")
+	}
+}
+
+func TestClient_CheckHealthOnShutdown(t *testing.T) {
+	s := server.NewServer(server.WithDrainDelay(300 * time.Millisecond))
+	s.RegisterWithName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	time.Sleep(500 * time.Millisecond)
+
+	opt := DefaultOption
+	opt.SerializeType = protocol.ProtoBuffer
+	c := NewClient(opt)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	status, err := c.CheckHealth(context.Background(), "Arith")
+	if err != nil || status != share.Serving {
+		t.Fatalf("expect SERVING but got %v, %v", status, err)
+	}
+	if _, err := c.CheckHealth(context.Background(), "NotExist"); err == nil {
+		t.Fatal("expect an error for an unknown service")
+	}
+
+	go s.Shutdown(context.Background())
+	time.Sleep(100 * time.Millisecond)
+
+	status, err = c.CheckHealth(context.Background(), "Arith")
+	if err != nil || status != share.NotServing {
+		t.Fatalf("expect NOT_SERVING but got %v, %v", status, err)
+	}
+}
+
+func TestXClient_BreakerPerServer(t *testing.T) {
+	var calls [2]int32
+	var pairs []*KVPair
+	for i := 0; i < 2; i++ {
+		i := i
+		s := server.NewServer()
+		server.RegisterHandler(s, "Arith", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+			atomic.AddInt32(&calls[i], 1)
+			if i == 0 {
+				return nil, errors.New("bad backend")
+			}
+			return &Reply{C: args.A * args.B}, nil
+		}, "")
+		go s.Serve("tcp", "127.0.0.1:0")
+		defer s.Close()
+		time.Sleep(200 * time.Millisecond)
+		pairs = append(pairs, &KVPair{Key: "tcp@" + s.Address().String()})
+	}
+
+	opt := DefaultOption
+	opt.BreakerFactory = func(server, serviceMethod string) Breaker {
+		return breaker.NewBreaker(breaker.Settings{
+			Name:    server,
+			Timeout: time.Minute,
+			ReadyToTrip: func(counts breaker.Counts) bool {
+				return counts.ConsecutiveFailures >= 2
+			},
+		})
+	}
+	xclient := NewXClient("Arith", Failfast, RoundRobin, NewMultipleServersDiscovery(pairs), opt)
+	defer xclient.Close()
+
+	for i := 0; i < 20; i++ {
+		xclient.Call(context.Background(), "Mul", &Args{A: 1, B: 2}, &Reply{})
+	}
+
+	if n := atomic.LoadInt32(&calls[0]); n != 2 {
+		t.Fatalf("expect the bad server to be called twice but got %d", n)
+	}
+	if n := atomic.LoadInt32(&calls[1]); n != 18 {
+		t.Fatalf("expect the good server to be called 18 times but got %d", n)
+	}
+
+	states := xclient.BreakerStates()
+	if states[pairs[0].Key] != breaker.Open || states[pairs[1].Key] != breaker.Closed {
+		t.Fatalf("unexpected breaker states: %v", states)
+	}
+}
@@ -0,0 +1,43 @@
+// protoc-gen-phobos is a protoc plugin that generates typed Phobos client
+// stubs and server interfaces for the services defined in .proto files.
+//
+// Install it into $PATH and run it together with protoc-gen-go:
+//
+//	go install github.com/marsevilspirit/phobos/cmd/protoc-gen-phobos
+//	protoc --go_out=. --phobos_out=. arith.proto
+//
+// For every service Foo it generates:
+//   - FooServer, the interface implemented by the service, and
+//     RegisterFooServer to register it on a server.Server.
+//   - FooClient, a typed client with one method per RPC, created by
+//     NewFooClient from a client.XClient.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+const version = "v0.1.0"
+
+func main() {
+	if len(os.Args) == 2 && os.Args[1] == "--version" {
+		fmt.Fprintf(os.Stdout, "%v %v\n", filepath.Base(os.Args[0]), version)
+		os.Exit(0)
+	}
+
+	protogen.Options{}.Run(func(gen *protogen.Plugin) error {
+		gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+
+		for _, f := range gen.Files {
+			if f.Generate {
+				generateFile(gen, f)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func arithRequest() *pluginpb.CodeGeneratorRequest {
+	method := func(name string, clientStreaming, serverStreaming bool) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(name),
+			InputType:       proto.String(".arith.Args"),
+			OutputType:      proto.String(".arith.Reply"),
+			ClientStreaming: proto.Bool(clientStreaming),
+			ServerStreaming: proto.Bool(serverStreaming),
+		}
+	}
+	field := func(name string, number int32) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+		}
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("arith.proto"),
+		Package: proto.String("arith"),
+		Syntax:  proto.String("proto3"),
+		Options: &descriptorpb.FileOptions{
+			GoPackage: proto.String("example.com/arith;arith"),
+		},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Args"), Field: []*descriptorpb.FieldDescriptorProto{field("a", 1), field("b", 2)}},
+			{Name: proto.String("Reply"), Field: []*descriptorpb.FieldDescriptorProto{field("c", 1)}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("Arith"),
+				Method: []*descriptorpb.MethodDescriptorProto{
+					method("Mul", false, false),
+					method("Count", false, true),
+					method("Sum", true, false),
+					method("MulStream", true, true),
+				},
+			},
+		},
+	}
+
+	return &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"arith.proto"},
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{file},
+	}
+}
+
+func TestGenerateFile(t *testing.T) {
+	gen, err := protogen.Options{}.New(arithRequest())
+	if err != nil {
+		t.Fatalf("failed to create plugin: %v", err)
+	}
+
+	for _, f := range gen.Files {
+		if f.Generate {
+			generateFile(gen, f)
+		}
+	}
+
+	res := gen.Response()
+	if res.Error != nil {
+		t.Fatalf("failed to generate: %s", res.GetError())
+	}
+	if len(res.File) != 1 {
+		t.Fatalf("expect 1 file but got %d", len(res.File))
+	}
+	if name := res.File[0].GetName(); name != "example.com/arith/arith_phobos.pb.go" {
+		t.Fatalf("unexpected file name %s", name)
+	}
+
+	content := res.File[0].GetContent()
+	for _, want := range []string{
+		"package arith",
+		`const ArithServiceName = "Arith"`,
+		"Mul(ctx context.Context, args *Args, reply *Reply) error",
+		"Count(ctx context.Context, args *Args, stream Arith_CountServer) error",
+		"Sum(ctx context.Context, stream Arith_SumServer) error",
+		"MulStream(ctx context.Context, stream Arith_MulStreamServer) error",
+		"func RegisterArithServer(s *server.Server, impl ArithServer, metadata string) error",
+		`server.RegisterHandler(s, ArithServiceName, "Mul"`,
+		`s.RegisterStream(ArithServiceName, "MulStream"`,
+		"func NewArithClient(xclient client.XClient) ArithClient",
+		"Mul(ctx context.Context, args *Args) (*Reply, error)",
+		"Sum(ctx context.Context) (Arith_SumClient, error)",
+		"CloseAndRecv() (*Reply, error)",
+		"SendAndClose(*Reply) error",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("generated code does not contain %q", want)
+		}
+	}
+	if strings.Contains(content, "RegisterWithName") {
+		t.Error("expect the streaming methods not to be registered by RegisterWithName")
+	}
+
+	if formatted, err := format.Source([]byte(content)); err != nil {
+		t.Fatalf("generated code is invalid: %v", err)
+	} else if string(formatted) != content {
+		t.Error("generated code is not gofmt-ed")
+	}
+	typeCheck(t, content)
+}
+
+// argsStub stands for the messages generated by protoc-gen-go.
+const argsStub = `package arith
+
+type Args struct{ A, B int32 }
+
+type Reply struct{ C int32 }
+`
+
+// typeCheck type-checks the generated code with the phobos packages.
+func typeCheck(t *testing.T, content string) {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for name, src := range map[string]string{"arith_phobos.pb.go": content, "arith.pb.go": argsStub} {
+		f, err := parser.ParseFile(fset, name, src, 0)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", name, err)
+		}
+		files = append(files, f)
+	}
+
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("example.com/arith", fset, files, nil); err != nil {
+		t.Fatalf("generated code does not type-check: %v", err)
+	}
+}
+
+func TestGenerateFile_NoService(t *testing.T) {
+	req := arithRequest()
+	req.ProtoFile[0].Service = nil
+
+	gen, err := protogen.Options{}.New(req)
+	if err != nil {
+		t.Fatalf("failed to create plugin: %v", err)
+	}
+
+	for _, f := range gen.Files {
+		if g := generateFile(gen, f); g != nil {
+			t.Fatal("expect no file for a proto without services")
+		}
+	}
+	if n := len(gen.Response().File); n != 0 {
+		t.Fatalf("expect no file but got %d", n)
+	}
+}
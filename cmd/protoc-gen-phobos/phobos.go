@@ -0,0 +1,332 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const (
+	contextPackage = protogen.GoImportPath("context")
+	ioPackage      = protogen.GoImportPath("io")
+	clientPackage  = protogen.GoImportPath("github.com/marsevilspirit/phobos/client")
+	serverPackage  = protogen.GoImportPath("github.com/marsevilspirit/phobos/server")
+)
+
+// generateFile generates a _phobos.pb.go file for the services of file.
+func generateFile(gen *protogen.Plugin, file *protogen.File) *protogen.GeneratedFile {
+	if len(file.Services) == 0 {
+		return nil
+	}
+
+	filename := file.GeneratedFilenamePrefix + "_phobos.pb.go"
+	g := gen.NewGeneratedFile(filename, file.GoImportPath)
+
+	g.P("// Code generated by protoc-gen-phobos. DO NOT EDIT.")
+	g.P("// versions:")
+	g.P("// \tprotoc-gen-phobos ", version)
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+
+	for _, service := range file.Services {
+		generateService(g, service)
+	}
+
+	return g
+}
+
+func generateService(g *protogen.GeneratedFile, service *protogen.Service) {
+	name := service.GoName
+
+	g.P("// ", name, "ServiceName is the service path of the ", name, " service.")
+	g.P("const ", name, "ServiceName = ", strconv.Quote(string(service.Desc.Name())))
+	g.P()
+
+	generateServer(g, service)
+	generateClient(g, service)
+}
+
+func isStream(method *protogen.Method) bool {
+	return method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer()
+}
+
+func streamTypeName(service *protogen.Service, method *protogen.Method, side string) string {
+	return service.GoName + "_" + method.GoName + side
+}
+
+func unexport(s string) string {
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+func generateServer(g *protogen.GeneratedFile, service *protogen.Service) {
+	name := service.GoName
+	serverType := name + "Server"
+
+	// server interface
+	g.P("// ", serverType, " is the server API for the ", name, " service.")
+	g.P("type ", serverType, " interface {")
+	for _, method := range service.Methods {
+		g.P(method.Comments.Leading, serverSignature(g, service, method))
+	}
+	g.P("}")
+	g.P()
+
+	// register function
+	g.P("// Register", serverType, " registers impl as the ", name, " service of s.")
+	g.P("func Register", serverType, "(s *", serverPackage.Ident("Server"), ", impl ", serverType, ", metadata string) error {")
+	// 逐个注册普通方法, impl 上的流式方法不符合 Register 的要求
+	for _, method := range service.Methods {
+		if isStream(method) {
+			continue
+		}
+		g.P("if err := ", serverPackage.Ident("RegisterHandler"), "(s, ", name, "ServiceName, ", strconv.Quote(method.GoName), ", func(ctx ", contextPackage.Ident("Context"), ", args *", method.Input.GoIdent, ") (*", method.Output.GoIdent, ", error) {")
+		g.P("reply := new(", method.Output.GoIdent, ")")
+		g.P("if err := impl.", method.GoName, "(ctx, args, reply); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return reply, nil")
+		g.P("}, metadata); err != nil {")
+		g.P("return err")
+		g.P("}")
+	}
+	for _, method := range service.Methods {
+		if !isStream(method) {
+			continue
+		}
+		g.P("if err := s.RegisterStream(", name, "ServiceName, ", strconv.Quote(method.GoName), ", func(ctx ", contextPackage.Ident("Context"), ", stream ", serverPackage.Ident("Stream"), ") error {")
+		wrapper := "&" + unexport(streamTypeName(service, method, "Server")) + "{stream}"
+		if method.Desc.IsStreamingServer() && !method.Desc.IsStreamingClient() {
+			g.P("args := new(", method.Input.GoIdent, ")")
+			g.P("if err := stream.Recv(args); err != nil {")
+			g.P("return err")
+			g.P("}")
+			g.P("return impl.", method.GoName, "(ctx, args, ", wrapper, ")")
+		} else {
+			g.P("return impl.", method.GoName, "(ctx, ", wrapper, ")")
+		}
+		g.P("}, metadata); err != nil {")
+		g.P("return err")
+		g.P("}")
+	}
+	g.P("return nil")
+	g.P("}")
+	g.P()
+
+	for _, method := range service.Methods {
+		if isStream(method) {
+			generateServerStream(g, service, method)
+		}
+	}
+}
+
+func serverSignature(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method) string {
+	ctx := "ctx " + g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	streamType := streamTypeName(service, method, "Server")
+
+	switch {
+	case !isStream(method):
+		return method.GoName + "(" + ctx + ", args *" + g.QualifiedGoIdent(method.Input.GoIdent) + ", reply *" + g.QualifiedGoIdent(method.Output.GoIdent) + ") error"
+	case !method.Desc.IsStreamingClient():
+		return method.GoName + "(" + ctx + ", args *" + g.QualifiedGoIdent(method.Input.GoIdent) + ", stream " + streamType + ") error"
+	default:
+		return method.GoName + "(" + ctx + ", stream " + streamType + ") error"
+	}
+}
+
+func generateServerStream(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method) {
+	typeName := streamTypeName(service, method, "Server")
+	implName := unexport(typeName)
+	clientStreaming := method.Desc.IsStreamingClient()
+	serverStreaming := method.Desc.IsStreamingServer()
+
+	g.P("// ", typeName, " is the server side stream of ", service.GoName, ".", method.GoName, ".")
+	g.P("type ", typeName, " interface {")
+	switch {
+	case serverStreaming:
+		g.P("Send(*", method.Output.GoIdent, ") error")
+	default:
+		g.P("// SendAndClose sends the reply, the handler should return after it.")
+		g.P("SendAndClose(*", method.Output.GoIdent, ") error")
+	}
+	if clientStreaming {
+		g.P("Recv() (*", method.Input.GoIdent, ", error)")
+	}
+	g.P("Context() ", contextPackage.Ident("Context"))
+	g.P("}")
+	g.P()
+
+	g.P("type ", implName, " struct {")
+	g.P(serverPackage.Ident("Stream"))
+	g.P("}")
+	g.P()
+
+	if serverStreaming {
+		g.P("func (x *", implName, ") Send(m *", method.Output.GoIdent, ") error {")
+	} else {
+		g.P("func (x *", implName, ") SendAndClose(m *", method.Output.GoIdent, ") error {")
+	}
+	g.P("return x.Stream.Send(m)")
+	g.P("}")
+	g.P()
+
+	if clientStreaming {
+		g.P("func (x *", implName, ") Recv() (*", method.Input.GoIdent, ", error) {")
+		g.P("m := new(", method.Input.GoIdent, ")")
+		g.P("if err := x.Stream.Recv(m); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return m, nil")
+		g.P("}")
+		g.P()
+	}
+}
+
+func generateClient(g *protogen.GeneratedFile, service *protogen.Service) {
+	name := service.GoName
+	clientType := name + "Client"
+	implName := unexport(clientType)
+
+	// client interface
+	g.P("// ", clientType, " is the typed client API for the ", name, " service.")
+	g.P("type ", clientType, " interface {")
+	for _, method := range service.Methods {
+		g.P(method.Comments.Leading, clientSignature(g, service, method))
+	}
+	g.P("}")
+	g.P()
+
+	g.P("type ", implName, " struct {")
+	g.P("xclient ", clientPackage.Ident("XClient"))
+	g.P("}")
+	g.P()
+
+	g.P("// New", clientType, " creates a ", clientType, ". xclient must be created for ", name, "ServiceName.")
+	g.P("func New", clientType, "(xclient ", clientPackage.Ident("XClient"), ") ", clientType, " {")
+	g.P("return &", implName, "{xclient: xclient}")
+	g.P("}")
+	g.P()
+
+	for _, method := range service.Methods {
+		g.P("func (c *", implName, ") ", clientSignature(g, service, method), " {")
+		streamImpl := unexport(streamTypeName(service, method, "Client"))
+		switch {
+		case !isStream(method):
+			g.P("reply := new(", method.Output.GoIdent, ")")
+			g.P("if err := c.xclient.Call(ctx, ", strconv.Quote(method.GoName), ", args, reply); err != nil {")
+			g.P("return nil, err")
+			g.P("}")
+			g.P("return reply, nil")
+		case !method.Desc.IsStreamingClient():
+			g.P("stream, err := c.xclient.NewStream(ctx, ", strconv.Quote(method.GoName), ")")
+			g.P("if err != nil {")
+			g.P("return nil, err")
+			g.P("}")
+			g.P("if err := stream.Send(args); err != nil {")
+			g.P("stream.Close()")
+			g.P("return nil, err")
+			g.P("}")
+			g.P("if err := stream.CloseSend(); err != nil {")
+			g.P("stream.Close()")
+			g.P("return nil, err")
+			g.P("}")
+			g.P("return &", streamImpl, "{stream}, nil")
+		default:
+			g.P("stream, err := c.xclient.NewStream(ctx, ", strconv.Quote(method.GoName), ")")
+			g.P("if err != nil {")
+			g.P("return nil, err")
+			g.P("}")
+			g.P("return &", streamImpl, "{stream}, nil")
+		}
+		g.P("}")
+		g.P()
+	}
+
+	for _, method := range service.Methods {
+		if isStream(method) {
+			generateClientStream(g, service, method)
+		}
+	}
+}
+
+func clientSignature(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method) string {
+	ctx := "ctx " + g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	streamType := streamTypeName(service, method, "Client")
+
+	switch {
+	case !isStream(method):
+		return method.GoName + "(" + ctx + ", args *" + g.QualifiedGoIdent(method.Input.GoIdent) + ") (*" + g.QualifiedGoIdent(method.Output.GoIdent) + ", error)"
+	case !method.Desc.IsStreamingClient():
+		return method.GoName + "(" + ctx + ", args *" + g.QualifiedGoIdent(method.Input.GoIdent) + ") (" + streamType + ", error)"
+	default:
+		return method.GoName + "(" + ctx + ") (" + streamType + ", error)"
+	}
+}
+
+func generateClientStream(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method) {
+	typeName := streamTypeName(service, method, "Client")
+	implName := unexport(typeName)
+	clientStreaming := method.Desc.IsStreamingClient()
+	serverStreaming := method.Desc.IsStreamingServer()
+
+	g.P("// ", typeName, " is the client side stream of ", service.GoName, ".", method.GoName, ".")
+	g.P("// Close must be called to release the stream.")
+	g.P("type ", typeName, " interface {")
+	if clientStreaming {
+		g.P("Send(*", method.Input.GoIdent, ") error")
+	}
+	switch {
+	case clientStreaming && serverStreaming:
+		g.P("Recv() (*", method.Output.GoIdent, ", error)")
+		g.P("CloseSend() error")
+	case clientStreaming:
+		g.P("CloseAndRecv() (*", method.Output.GoIdent, ", error)")
+	default:
+		g.P("Recv() (*", method.Output.GoIdent, ", error)")
+	}
+	g.P("Context() ", contextPackage.Ident("Context"))
+	g.P("Close() error")
+	g.P("}")
+	g.P()
+
+	g.P("type ", implName, " struct {")
+	g.P(clientPackage.Ident("Stream"))
+	g.P("}")
+	g.P()
+
+	if clientStreaming {
+		g.P("func (x *", implName, ") Send(m *", method.Input.GoIdent, ") error {")
+		g.P("return x.Stream.Send(m)")
+		g.P("}")
+		g.P()
+	}
+
+	if serverStreaming {
+		g.P("func (x *", implName, ") Recv() (*", method.Output.GoIdent, ", error) {")
+		g.P("m := new(", method.Output.GoIdent, ")")
+		g.P("if err := x.Stream.Recv(m); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return m, nil")
+		g.P("}")
+		g.P()
+		return
+	}
+
+	g.P("func (x *", implName, ") CloseAndRecv() (*", method.Output.GoIdent, ", error) {")
+	g.P("if err := x.Stream.CloseSend(); err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("m := new(", method.Output.GoIdent, ")")
+	g.P("if err := x.Stream.Recv(m); err != nil {")
+	g.P("if err == ", ioPackage.Ident("EOF"), " {")
+	g.P("err = ", ioPackage.Ident("ErrUnexpectedEOF"))
+	g.P("}")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("return m, nil")
+	g.P("}")
+	g.P()
+}
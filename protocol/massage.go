@@ -85,6 +85,7 @@ func NewMessage() *Message {
 //
 // h[2]的第8位是MessageType, 第7位是IsHeartbeat, 第6位是IsOneway
 // 第5-3位是CompressType
+// h[3]的高4位是SerializeType, 第4位是IsCancel, 低3位是StreamFrameType
 type Header [12]byte
 
 func (h Header) CheckMagicNumber() bool {
@@ -133,6 +134,20 @@ func (h *Header) SetOneway(oneway bool) {
 	}
 }
 
+// IsCancel reports whether the message is a cancellation frame, which asks
+// the server to cancel the in-flight request with the same Seq.
+func (h Header) IsCancel() bool {
+	return h[3]&0x08 == 0x08
+}
+
+func (h *Header) SetCancel(cancel bool) {
+	if cancel {
+		h[3] = h[3] | 0x08
+	} else {
+		h[3] = h[3] &^ 0x08
+	}
+}
+
 func (h Header) CompressType() CompressType {
 	return CompressType((h[2] & 0x1C) >> 2)
 }
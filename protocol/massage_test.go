@@ -50,6 +50,24 @@ func TestHeader_Oneway(t *testing.T) {
 	}
 }
 
+func TestHeader_Cancel(t *testing.T) {
+	header := Header([12]byte{})
+	header.SetSerializeType(MsgPack)
+
+	header.SetCancel(true)
+	if !header.IsCancel() {
+		t.Errorf("expected Cancel true, got false")
+	}
+	if header.SerializeType() != MsgPack {
+		t.Errorf("expected serialize type %d, got %d", MsgPack, header.SerializeType())
+	}
+
+	header.SetCancel(false)
+	if header.IsCancel() {
+		t.Errorf("expected Cancel false, got true")
+	}
+}
+
 func TestHeader_CompressType(t *testing.T) {
 	header := Header([12]byte{})
 
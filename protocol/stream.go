@@ -0,0 +1,124 @@
+package protocol
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// DefaultStreamWindow is the number of frames a peer may send on a stream
+// before it has to wait for a window update from the receiver.
+const DefaultStreamWindow = 64
+
+var ErrStreamWindowClosed = errors.New("stream window is closed")
+
+// StreamFrameType is the type of a stream frame.
+// It is stored in the low 3 bits of h[3], 0 means the message is not a stream frame.
+type StreamFrameType byte
+
+const (
+	StreamNone StreamFrameType = iota
+	// StreamOpen opens a stream, it carries ServicePath, ServiceMethod and Metadata.
+	StreamOpen
+	// StreamData carries one message of the stream in Payload.
+	StreamData
+	// StreamEnd means the sender will not send any more data.
+	StreamEnd
+	// StreamError ends the stream with the error in Metadata[ServiceError].
+	StreamError
+	// StreamWindowUpdate grants the peer more frames to send, see EncodeWindowUpdate.
+	StreamWindowUpdate
+)
+
+func (h Header) StreamFrameType() StreamFrameType {
+	return StreamFrameType(h[3] & 0x07)
+}
+
+func (h *Header) SetStreamFrameType(t StreamFrameType) {
+	h[3] = (h[3] &^ 0x07) | (byte(t) & 0x07)
+}
+
+// EncodeWindowUpdate encodes the payload of a StreamWindowUpdate frame.
+func EncodeWindowUpdate(n uint32) []byte {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, n)
+	return data
+}
+
+// DecodeWindowUpdate decodes the payload of a StreamWindowUpdate frame.
+func DecodeWindowUpdate(data []byte) uint32 {
+	if len(data) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(data)
+}
+
+// StreamWindow is the credit based flow control of the sending side of a stream.
+// Every frame sent consumes one credit, and the receiver gives credits back
+// with StreamWindowUpdate frames once the application has read the frames.
+type StreamWindow struct {
+	mu      sync.Mutex
+	credits int
+	closed  bool
+	notify  chan struct{}
+}
+
+func NewStreamWindow(credits int) *StreamWindow {
+	return &StreamWindow{
+		credits: credits,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// Acquire takes one credit, it blocks until a credit is available,
+// the window is closed or ctx is done.
+func (w *StreamWindow) Acquire(ctx context.Context) error {
+	for {
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			return ErrStreamWindowClosed
+		}
+		if w.credits > 0 {
+			w.credits--
+			more := w.credits > 0
+			w.mu.Unlock()
+			if more {
+				// 还有剩余额度，唤醒其他等待者
+				w.signal()
+			}
+			return nil
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-w.notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release gives n credits back to the window.
+func (w *StreamWindow) Release(n int) {
+	w.mu.Lock()
+	w.credits += n
+	w.mu.Unlock()
+	w.signal()
+}
+
+// Close wakes up all waiters, Acquire returns ErrStreamWindowClosed afterwards.
+func (w *StreamWindow) Close() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	w.signal()
+}
+
+func (w *StreamWindow) signal() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
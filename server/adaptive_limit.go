@@ -0,0 +1,159 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/marsevilspirit/phobos/share"
+)
+
+// AdaptiveLimit configures an adaptive concurrency limiter. It compares the
+// recent latency of the handlers with their long-term latency, and lowers the
+// allowed in-flight requests when the latency rises, in the way of the gradient
+// limiter of Netflix concurrency-limits. The requests beyond the limit are
+// rejected with ErrServerOverloaded.
+//
+// A stream counts as an in-flight request until it ends, and the streams
+// opened beyond the limit are rejected. The duration of a stream depends on
+// the client, so it is not used as a latency sample.
+type AdaptiveLimit struct {
+	// InitialLimit is the limit before there are samples, 20 by default.
+	InitialLimit int
+	// MinLimit is the lower bound of the limit, 1 by default.
+	MinLimit int
+	// MaxLimit is the upper bound of the limit, 1000 by default.
+	MaxLimit int
+	// Smoothing is how fast the limit moves to a new value, in (0, 1], 0.2 by default.
+	Smoothing float64
+	// Tolerance is how much the latency may grow before the limit is lowered,
+	// 1.5 means up to 50% slower than the long-term latency. 1.5 by default.
+	Tolerance float64
+}
+
+// AdaptiveLimitStats is the state of an adaptive concurrency limiter.
+type AdaptiveLimitStats struct {
+	Limit    int
+	Inflight int
+}
+
+// 短期和长期延迟的 EWMA 窗口, 以样本数计
+const (
+	adaptiveShortWindow = 10
+	adaptiveLongWindow  = 600
+)
+
+type adaptiveLimiter struct {
+	AdaptiveLimit
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	shortRTT float64
+	longRTT  float64
+}
+
+func newAdaptiveLimiter(cfg AdaptiveLimit) *adaptiveLimiter {
+	if cfg.MinLimit <= 0 {
+		cfg.MinLimit = 1
+	}
+	if cfg.MaxLimit <= 0 {
+		cfg.MaxLimit = 1000
+	}
+	if cfg.MaxLimit < cfg.MinLimit {
+		cfg.MaxLimit = cfg.MinLimit
+	}
+	if cfg.InitialLimit <= 0 {
+		cfg.InitialLimit = 20
+	}
+	cfg.InitialLimit = min(max(cfg.InitialLimit, cfg.MinLimit), cfg.MaxLimit)
+	if cfg.Smoothing <= 0 || cfg.Smoothing > 1 {
+		cfg.Smoothing = 0.2
+	}
+	if cfg.Tolerance < 1 {
+		cfg.Tolerance = 1.5
+	}
+
+	return &adaptiveLimiter{AdaptiveLimit: cfg, limit: float64(cfg.InitialLimit)}
+}
+
+// acquire reports whether a request is allowed, the request must release
+// the limiter if it is allowed.
+func (l *adaptiveLimiter) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inflight >= int(l.limit) {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+// release releases a request which took rtt to handle, rtt is 0 if the
+// request was not handled.
+func (l *adaptiveLimiter) release(rtt time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inflight := l.inflight
+	l.inflight--
+	if rtt <= 0 {
+		return
+	}
+
+	sample := float64(rtt)
+	if l.longRTT == 0 {
+		l.shortRTT = sample
+		l.longRTT = sample
+		return
+	}
+	l.shortRTT += (sample - l.shortRTT) / adaptiveShortWindow
+	l.longRTT += (sample - l.longRTT) / adaptiveLongWindow
+	// 延迟长期下降之后, 让长期延迟更快地跟上
+	if l.longRTT > 2*l.shortRTT {
+		l.longRTT = 2 * l.shortRTT
+	}
+
+	// 请求数远低于上限时, 延迟反映不了上限是否合适
+	if float64(inflight) < l.limit/2 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, l.Tolerance*l.longRTT/l.shortRTT))
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	newLimit = l.limit*(1-l.Smoothing) + newLimit*l.Smoothing
+	l.limit = math.Max(float64(l.MinLimit), math.Min(float64(l.MaxLimit), newLimit))
+}
+
+func (l *adaptiveLimiter) stats() AdaptiveLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return AdaptiveLimitStats{Limit: int(l.limit), Inflight: l.inflight}
+}
+
+// adaptiveLimiter returns the adaptive limiter of servicePath, or nil if the
+// service is not limited. The built-in services are never limited.
+func (s *Server) adaptiveLimiter(servicePath string) *adaptiveLimiter {
+	if len(s.adaptiveLimits) == 0 ||
+		servicePath == share.HealthServicePath || servicePath == share.ReflectionServicePath {
+		return nil
+	}
+
+	if l, ok := s.adaptiveLimits[servicePath]; ok {
+		return l
+	}
+	return s.adaptiveLimits[""]
+}
+
+// AdaptiveLimits returns the current state of the adaptive concurrency limiters,
+// keyed by the service path, or the empty string for the limiter of the whole server.
+func (s *Server) AdaptiveLimits() map[string]AdaptiveLimitStats {
+	stats := make(map[string]AdaptiveLimitStats, len(s.adaptiveLimits))
+	for servicePath, l := range s.adaptiveLimits {
+		stats[servicePath] = l.stats()
+	}
+
+	return stats
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/marsevilspirit/phobos/protocol"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	l := newAdaptiveLimiter(AdaptiveLimit{InitialLimit: 10, MaxLimit: 100})
+
+	// 延迟稳定且请求数接近上限时, 上限增长
+	for i := 0; i < 50; i++ {
+		for j := 0; j < int(l.limit); j++ {
+			if !l.acquire() {
+				t.Fatalf("expect request %d within the limit to be allowed", j)
+			}
+		}
+		if l.acquire() {
+			t.Fatal("expect request beyond the limit to be rejected")
+		}
+		for l.inflight > 0 {
+			l.release(10 * time.Millisecond)
+		}
+	}
+	grown := l.stats().Limit
+	if grown <= 10 {
+		t.Fatalf("expect limit to grow, got %d", grown)
+	}
+
+	// 延迟升高时, 上限下降
+	for i := 0; i < 50; i++ {
+		for j := 0; j < int(l.limit); j++ {
+			l.acquire()
+		}
+		for l.inflight > 0 {
+			l.release(100 * time.Millisecond)
+		}
+	}
+	if st := l.stats(); st.Limit >= grown || st.Inflight != 0 {
+		t.Fatalf("expect limit to shrink below %d, got %+v", grown, st)
+	}
+}
+
+func TestServerAdaptiveLimit(t *testing.T) {
+	block := &Block{release: make(chan struct{})}
+
+	s := NewServer(WithAdaptiveLimit("Block", AdaptiveLimit{InitialLimit: 1, MaxLimit: 1}))
+	s.RegisterWithName("Block", block, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	writeMulRequest(t, conn, "Block", 1)
+	writeMulRequest(t, conn, "Block", 2)
+
+	res, err := protocol.Read(conn)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if res.Seq() != 2 || res.Metadata[protocol.ServiceError] != ErrServerOverloaded.Error() {
+		t.Fatalf("expect request 2 to be rejected, got seq %d", res.Seq())
+	}
+	if st := s.AdaptiveLimits()["Block"]; st.Limit != 1 || st.Inflight != 1 {
+		t.Fatalf("expect limit 1 with 1 in-flight request, got %+v", st)
+	}
+
+	close(block.release)
+	res, err = protocol.Read(conn)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if res.Seq() != 1 || res.MessageStatusType() != protocol.Normal {
+		t.Fatalf("expect request 1 to succeed, got seq %d", res.Seq())
+	}
+}
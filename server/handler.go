@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+
+	"github.com/marsevilspirit/phobos/protocol"
+	"github.com/marsevilspirit/phobos/share"
+)
+
+type handlerType struct {
+	newArgs func() any
+	// call 调用类型化的处理函数, args 是 newArgs 创建并解码后的参数
+	call func(ctx context.Context, args any) (any, error)
+	// 仅用于反射服务描述方法, 调用时不使用
+	ArgType   reflect.Type
+	ReplyType reflect.Type
+}
+
+// RegisterHandler registers fn as the method servicePath.method.
+// Unlike Register, the signature of fn is checked at compile time and no
+// reflection is used to call it. Handlers can be registered on a service that
+// also has methods registered by Register or RegisterStream.
+func RegisterHandler[Req, Resp any](s *Server, servicePath, method string, fn func(ctx context.Context, req *Req) (*Resp, error), metadata string) error {
+	if servicePath == "" || method == "" {
+		return errors.New("phobos.RegisterHandler: servicePath and method can't be empty")
+	}
+	if fn == nil {
+		return errors.New("phobos.RegisterHandler: handler is nil")
+	}
+
+	h := newHandlerType(fn)
+
+	s.serviceMapMu.Lock()
+	ss, isNew := s.copyServiceLocked(servicePath)
+	ss.handler = maps.Clone(ss.handler)
+	if ss.handler == nil {
+		ss.handler = make(map[string]*handlerType)
+	}
+	ss.handler[method] = h
+	if metadata != "" {
+		ss.metadata = metadata
+	}
+	s.serviceMap[servicePath] = ss
+	s.serviceMapMu.Unlock()
+
+	if s.Plugins == nil {
+		s.Plugins = &pluginContainer{}
+	}
+	if isNew {
+		return s.Plugins.DoRegister(servicePath, fn, metadata)
+	}
+
+	return nil
+}
+
+func newHandlerType[Req, Resp any](fn func(ctx context.Context, req *Req) (*Resp, error)) *handlerType {
+	call := func(ctx context.Context, args any) (reply any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("[service internal] error: %v", r)
+			}
+		}()
+
+		resp, err := fn(ctx, args.(*Req))
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil {
+			resp = new(Resp)
+		}
+
+		return resp, nil
+	}
+
+	return &handlerType{
+		newArgs:   func() any { return new(Req) },
+		call:      call,
+		ArgType:   reflect.TypeOf((*Req)(nil)),
+		ReplyType: reflect.TypeOf((*Resp)(nil)),
+	}
+}
+
+// copyServiceLocked returns a copy of the service servicePath to modify and
+// store back, or a new empty service if servicePath has not been registered.
+// The services are read without the lock while serving requests, so they are
+// never modified in place. s.serviceMapMu must be held.
+func (s *Server) copyServiceLocked(servicePath string) (ss *service, isNew bool) {
+	if s.serviceMap == nil {
+		s.serviceMap = make(map[string]*service)
+	}
+	old := s.serviceMap[servicePath]
+	if old == nil {
+		return &service{name: servicePath}, true
+	}
+
+	c := *old
+	return &c, false
+}
+
+func (s *Server) handleRequestForHandler(ctx context.Context, req *protocol.Message, res *protocol.Message, h *handlerType) (*protocol.Message, error) {
+	cc := share.Codecs[req.SerializeType()]
+	if cc == nil {
+		return handleError(res, fmt.Errorf("can not find codec for %d", req.SerializeType()))
+	}
+
+	args := h.newArgs()
+	if err := cc.Decode(req.Payload, args); err != nil {
+		return handleError(res, err)
+	}
+
+	info := &RequestInfo{ServicePath: req.ServicePath, ServiceMethod: req.ServiceMethod, Args: args}
+	reply, err := s.intercept(ctx, info, func(ctx context.Context, info *RequestInfo) (any, error) {
+		return h.call(ctx, info.Args)
+	})
+	if err != nil {
+		return handleError(res, err)
+	}
+
+	if !req.IsOneway() && reply != nil {
+		data, err := cc.Encode(reply)
+		if err != nil {
+			return handleError(res, err)
+		}
+		res.Payload = data
+	}
+
+	return res, nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/marsevilspirit/phobos/share"
+)
+
+// SetServingStatus sets the serving status of servicePath reported by the
+// built-in health service share.HealthServicePath. An empty servicePath sets
+// the status of the whole server, which overrides the status of every service
+// unless it is share.Serving.
+func (s *Server) SetServingStatus(servicePath string, status share.ServingStatus) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	if s.health == nil {
+		s.health = make(map[string]share.ServingStatus)
+	}
+	s.health[servicePath] = status
+}
+
+// ServingStatus returns the serving status of servicePath, or of the whole
+// server if servicePath is empty. Registered services are share.Serving unless
+// set otherwise, unknown services are share.ServingStatusUnknown.
+func (s *Server) ServingStatus(servicePath string) share.ServingStatus {
+	s.healthMu.RLock()
+	overall, ok := s.health[""]
+	status, set := s.health[servicePath]
+	s.healthMu.RUnlock()
+
+	if !ok {
+		overall = share.Serving
+	}
+	if servicePath == "" || overall != share.Serving {
+		return overall
+	}
+	if set {
+		return status
+	}
+
+	s.serviceMapMu.RLock()
+	_, registered := s.serviceMap[servicePath]
+	s.serviceMapMu.RUnlock()
+	if registered {
+		return share.Serving
+	}
+
+	return share.ServingStatusUnknown
+}
+
+// healthService implements the built-in service share.HealthServicePath.
+func (s *Server) healthService() map[string]*handlerType {
+	return map[string]*handlerType{
+		share.HealthCheck: newHandlerType(s.checkHealth),
+	}
+}
+
+func (s *Server) checkHealth(ctx context.Context, args *share.HealthCheckArgs) (*share.HealthCheckReply, error) {
+	status := s.ServingStatus(args.ServicePath)
+	if status == share.ServingStatusUnknown {
+		return nil, errors.New("phobos: can't find service " + args.ServicePath)
+	}
+
+	return &share.HealthCheckReply{Status: status}, nil
+}
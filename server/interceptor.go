@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"fmt"
+)
+
+// RequestInfo is the request an Interceptor is invoked for.
+type RequestInfo struct {
+	ServicePath   string
+	ServiceMethod string
+	// Args is the decoded argument of the request. An interceptor can replace
+	// it with another value of the same type before calling next.
+	Args any
+}
+
+// Handler calls the service method of req and returns its reply.
+type Handler func(ctx context.Context, req *RequestInfo) (reply any, err error)
+
+// Interceptor wraps the invocation of a service method. It can call next with
+// a new context, change the args, or return without calling next. The reply it
+// returns is encoded as the response, a nil reply means an empty payload.
+type Interceptor func(ctx context.Context, req *RequestInfo, next Handler) (reply any, err error)
+
+// WithInterceptors adds interceptors to the server. They are invoked in the
+// order they are added, the first one is the outermost.
+func WithInterceptors(interceptors ...Interceptor) OptionFn {
+	return func(s *Server) {
+		s.interceptors = append(s.interceptors, interceptors...)
+	}
+}
+
+// intercept calls h through the interceptors of the server.
+func (s *Server) intercept(ctx context.Context, req *RequestInfo, h Handler) (reply any, err error) {
+	if len(s.interceptors) == 0 {
+		return h(ctx, req)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("[service internal] error: %v", r)
+		}
+	}()
+
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := s.interceptors[i], h
+		h = func(ctx context.Context, req *RequestInfo) (any, error) {
+			return interceptor(ctx, req, next)
+		}
+	}
+
+	return h(ctx, req)
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/marsevilspirit/phobos/protocol"
+)
+
+type ctxKey struct{}
+
+func newMulRequest(servicePath string, a, b int) *protocol.Message {
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath = servicePath
+	req.ServiceMethod = "Mul"
+	req.Payload, _ = json.Marshal(&Args{A: a, B: b})
+	return req
+}
+
+func decodeReply(t *testing.T, res *protocol.Message) int {
+	t.Helper()
+	if e := res.Metadata[protocol.ServiceError]; e != "" {
+		t.Fatalf("unexpected error: %s", e)
+	}
+	reply := &Reply{}
+	if err := json.Unmarshal(res.Payload, reply); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return reply.C
+}
+
+func TestInterceptors(t *testing.T) {
+	var order []string
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, req *RequestInfo, next Handler) (any, error) {
+			order = append(order, name)
+			return next(ctx, req)
+		}
+	}
+	double := func(ctx context.Context, req *RequestInfo, next Handler) (any, error) {
+		args := *req.Args.(*Args)
+		args.A *= 2
+		req.Args = &args
+		reply, err := next(context.WithValue(ctx, ctxKey{}, "v"), req)
+		if err != nil {
+			return nil, err
+		}
+		return &Reply{C: reply.(*Reply).C + 1}, nil
+	}
+
+	s := NewServer(WithInterceptors(record("first"), record("second"), double))
+	s.RegisterWithName("Arith", new(Arith), "")
+	RegisterHandler(s, "Typed", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+		if ctx.Value(ctxKey{}) != "v" {
+			return nil, errors.New("expect the context of the interceptor")
+		}
+		return &Reply{C: args.A * args.B}, nil
+	}, "")
+	s.RegisterFunctionWithName("", "Mul", func(ctx context.Context, args *Args, reply *Reply) error {
+		reply.C = args.A * args.B
+		return nil
+	}, "")
+
+	for _, servicePath := range []string{"Arith", "Typed", ""} {
+		order = nil
+		res, _ := s.handleRequest(context.Background(), newMulRequest(servicePath, 10, 20))
+		if c := decodeReply(t, res); c != 401 {
+			t.Fatalf("expect 401 from %s but got %d", servicePath, c)
+		}
+		if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+			t.Fatalf("expect interceptors in order, got %v", order)
+		}
+	}
+}
+
+func TestInterceptorShortCircuit(t *testing.T) {
+	called := false
+	s := NewServer(WithInterceptors(func(ctx context.Context, req *RequestInfo, next Handler) (any, error) {
+		if req.Args.(*Args).A == 0 {
+			return &Reply{C: -1}, nil
+		}
+		return next(ctx, req)
+	}))
+	RegisterHandler(s, "Arith", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+		called = true
+		return &Reply{C: args.A * args.B}, nil
+	}, "")
+
+	res, _ := s.handleRequest(context.Background(), newMulRequest("Arith", 0, 20))
+	if c := decodeReply(t, res); c != -1 || called {
+		t.Fatalf("expect cached reply without calling the handler, got %d", c)
+	}
+}
+
+func TestInterceptorRecoverError(t *testing.T) {
+	errDB := errors.New("database is down")
+	s := NewServer(
+		WithInterceptors(func(ctx context.Context, req *RequestInfo, next Handler) (any, error) {
+			reply, err := next(ctx, req)
+			if errors.Is(err, errDB) {
+				return &Reply{}, nil
+			}
+			return reply, err
+		}),
+		WithInterceptors(func(ctx context.Context, req *RequestInfo, next Handler) (any, error) {
+			if req.Args.(*Args).A < 0 {
+				panic("negative")
+			}
+			return next(ctx, req)
+		}),
+	)
+	RegisterHandler(s, "Arith", "Mul", func(ctx context.Context, args *Args) (*Reply, error) {
+		return nil, errDB
+	}, "")
+
+	res, _ := s.handleRequest(context.Background(), newMulRequest("Arith", 10, 20))
+	if c := decodeReply(t, res); c != 0 {
+		t.Fatalf("expect the error to be recovered, got %d", c)
+	}
+
+	res, err := s.handleRequest(context.Background(), newMulRequest("Arith", -1, 20))
+	if err == nil || res.MessageStatusType() != protocol.Error {
+		t.Fatal("expect the panic of the interceptor to be returned as an error")
+	}
+}
@@ -19,8 +19,80 @@ func WithReadTimeout(readtimeout time.Duration) OptionFn {
 	}
 }
 
+// WithServiceTimeout sets the default timeout of every method of servicePath.
+// The timeout propagated by the caller still applies if it is shorter.
+func WithServiceTimeout(servicePath string, timeout time.Duration) OptionFn {
+	return func(s *Server) {
+		if s.timeouts == nil {
+			s.timeouts = make(map[string]time.Duration)
+		}
+		s.timeouts[servicePath] = timeout
+	}
+}
+
+// WithMethodTimeout sets the default timeout of servicePath.serviceMethod,
+// it takes precedence over the timeout set by WithServiceTimeout.
+func WithMethodTimeout(servicePath, serviceMethod string, timeout time.Duration) OptionFn {
+	return func(s *Server) {
+		if s.timeouts == nil {
+			s.timeouts = make(map[string]time.Duration)
+		}
+		s.timeouts[servicePath+"."+serviceMethod] = timeout
+	}
+}
+
 func WithWriteTimeout(writetimeout time.Duration) OptionFn {
 	return func(s *Server) {
 		s.writeTimeout = writetimeout
 	}
 }
+
+// WithoutReflection disables the built-in reflection service share.ReflectionServicePath,
+// so clients can't list the services of the server.
+func WithoutReflection() OptionFn {
+	return func(s *Server) {
+		s.disableReflection = true
+	}
+}
+
+// WithDrainDelay sets how long Shutdown keeps serving after it reports
+// NOT_SERVING by the health service, so clients have time to notice it.
+func WithDrainDelay(d time.Duration) OptionFn {
+	return func(s *Server) {
+		s.drainDelay = d
+	}
+}
+
+// WithWorkerPool runs the requests by at most size goroutines, and queues up
+// to queue requests waiting for them. The requests beyond the queue are
+// rejected with ErrServerOverloaded. Streams are long-lived and don't run by
+// the worker pool, WithMaxConcurrentRequestsPerConn limits them.
+func WithWorkerPool(size, queue int) OptionFn {
+	return func(s *Server) {
+		s.workerPool = newWorkerPool(size, queue)
+	}
+}
+
+// WithMaxConcurrentRequestsPerConn limits the requests and streams handled at
+// the same time for each connection. The server stops reading from a connection
+// that reaches the limit, so the client is slowed down by TCP flow control.
+// A stream holds a slot until it ends. The streams and, while streams hold
+// slots, the requests beyond the limit are rejected with ErrServerOverloaded,
+// as the server must keep reading the frames of the streams.
+func WithMaxConcurrentRequestsPerConn(n int) OptionFn {
+	return func(s *Server) {
+		s.maxConnInflight = n
+	}
+}
+
+// WithAdaptiveLimit limits the in-flight requests of servicePath by an adaptive
+// concurrency limiter. An empty servicePath sets the limiter shared by the
+// services without their own limiter.
+func WithAdaptiveLimit(servicePath string, cfg AdaptiveLimit) OptionFn {
+	return func(s *Server) {
+		if s.adaptiveLimits == nil {
+			s.adaptiveLimits = make(map[string]*adaptiveLimiter)
+		}
+		s.adaptiveLimits[servicePath] = newAdaptiveLimiter(cfg)
+	}
+}
@@ -51,6 +51,19 @@ type (
 		PostReadRequest(ctx context.Context, r *protocol.Message, e error) error
 	}
 
+	// PreHandleRequestPlugin is invoked before a request is handled or a stream
+	// is opened, the request is rejected with the error if it returns an error.
+	PreHandleRequestPlugin interface {
+		PreHandleRequest(ctx context.Context, r *protocol.Message) error
+	}
+
+	// PostHandleRequestPlugin is invoked after a request is handled, if the
+	// PreHandleRequest of the plugin has been invoked successfully. For a stream
+	// it is invoked after the stream ends, res is nil.
+	PostHandleRequestPlugin interface {
+		PostHandleRequest(ctx context.Context, r *protocol.Message, res *protocol.Message, e error)
+	}
+
 	PreWriteResponsePlugin interface {
 		PreWriteResponse(context.Context, *protocol.Message) error
 	}
@@ -172,6 +185,65 @@ func (p *pluginContainer) DoPostReadRequest(ctx context.Context, r *protocol.Mes
 	return nil
 }
 
+// DoPreHandleRequest invokes the PreHandleRequest of the plugins in order.
+// If a plugin rejects the request, the PostHandleRequest of the plugins
+// before it are invoked with the error.
+func (p *pluginContainer) DoPreHandleRequest(ctx context.Context, r *protocol.Message) error {
+	return preHandleRequest(p.plugins, ctx, r)
+}
+
+func (p *pluginContainer) DoPostHandleRequest(ctx context.Context, r *protocol.Message, res *protocol.Message, e error) {
+	postHandleRequest(p.plugins, ctx, r, res, e)
+}
+
+// handleRequestContainer is implemented by the PluginContainer invoking the
+// PreHandleRequestPlugin and PostHandleRequestPlugin plugins by itself.
+type handleRequestContainer interface {
+	DoPreHandleRequest(ctx context.Context, r *protocol.Message) error
+	DoPostHandleRequest(ctx context.Context, r *protocol.Message, res *protocol.Message, e error)
+}
+
+// doPreHandleRequest invokes the PreHandleRequestPlugin plugins of plugins.
+func doPreHandleRequest(plugins PluginContainer, ctx context.Context, r *protocol.Message) error {
+	if c, ok := plugins.(handleRequestContainer); ok {
+		return c.DoPreHandleRequest(ctx, r)
+	}
+
+	return preHandleRequest(plugins.All(), ctx, r)
+}
+
+// doPostHandleRequest invokes the PostHandleRequestPlugin plugins of plugins.
+func doPostHandleRequest(plugins PluginContainer, ctx context.Context, r *protocol.Message, res *protocol.Message, e error) {
+	if c, ok := plugins.(handleRequestContainer); ok {
+		c.DoPostHandleRequest(ctx, r, res, e)
+		return
+	}
+
+	postHandleRequest(plugins.All(), ctx, r, res, e)
+}
+
+func preHandleRequest(plugins []Plugin, ctx context.Context, r *protocol.Message) error {
+	for i, rp := range plugins {
+		if plugin, ok := rp.(PreHandleRequestPlugin); ok {
+			err := plugin.PreHandleRequest(ctx, r)
+			if err != nil {
+				postHandleRequest(plugins[:i], ctx, r, nil, err)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func postHandleRequest(plugins []Plugin, ctx context.Context, r *protocol.Message, res *protocol.Message, e error) {
+	for _, rp := range plugins {
+		if plugin, ok := rp.(PostHandleRequestPlugin); ok {
+			plugin.PostHandleRequest(ctx, r, res, e)
+		}
+	}
+}
+
 func (p *pluginContainer) DoPreWriteResponse(ctx context.Context, req *protocol.Message) error {
 	for _, rp := range p.plugins {
 		if plugin, ok := rp.(PreWriteResponsePlugin); ok {
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marsevilspirit/phobos/share"
+)
+
+var typeOfTime = reflect.TypeOf(time.Time{})
+
+// reflectionService implements the built-in service share.ReflectionServicePath.
+// It is not stored in serviceMap, so it is not listed nor registered to registries.
+func (s *Server) reflectionService() map[string]*handlerType {
+	return map[string]*handlerType{
+		share.ReflectionListServices: newHandlerType(s.listServices),
+	}
+}
+
+func (s *Server) listServices(ctx context.Context, args *share.ListServicesArgs) (*share.ListServicesReply, error) {
+	s.serviceMapMu.RLock()
+	defer s.serviceMapMu.RUnlock()
+
+	reply := &share.ListServicesReply{}
+	for name, ss := range s.serviceMap {
+		if args.ServicePath != "" && args.ServicePath != name {
+			continue
+		}
+		reply.Services = append(reply.Services, ss.describe())
+	}
+	if args.ServicePath != "" && len(reply.Services) == 0 {
+		return nil, errors.New("phobos: can't find service " + args.ServicePath)
+	}
+
+	sort.Slice(reply.Services, func(i, j int) bool {
+		return reply.Services[i].Name < reply.Services[j].Name
+	})
+
+	return reply, nil
+}
+
+// describe must be called with s.serviceMapMu held.
+func (ss *service) describe() *share.ServiceInfo {
+	info := &share.ServiceInfo{
+		Name:     ss.name,
+		Metadata: ss.metadata,
+	}
+
+	unary := func(name string, argType, replyType reflect.Type) {
+		info.Methods = append(info.Methods, &share.MethodInfo{
+			Name:      name,
+			Kind:      share.MethodKindUnary,
+			ArgType:   typeSchema(argType, nil),
+			ReplyType: typeSchema(replyType, nil),
+		})
+	}
+	for name, m := range ss.method {
+		unary(name, m.ArgType, m.ReplyType)
+	}
+	for name, f := range ss.function {
+		unary(name, f.ArgType, f.ReplyType)
+	}
+	for name, h := range ss.handler {
+		unary(name, h.ArgType, h.ReplyType)
+	}
+	for name := range ss.stream {
+		info.Methods = append(info.Methods, &share.MethodInfo{Name: name, Kind: share.MethodKindStream})
+	}
+
+	sort.Slice(info.Methods, func(i, j int) bool {
+		return info.Methods[i].Name < info.Methods[j].Name
+	})
+
+	return info
+}
+
+// typeSchema describes t. Struct fields are named as encoding/json does.
+// seen holds the structs being described, to stop at recursive types.
+func typeSchema(t reflect.Type, seen map[reflect.Type]bool) *share.TypeSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	schema := &share.TypeSchema{}
+	if t.Name() != "" {
+		schema.Name = t.String()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		schema.Type = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		schema.Type = "integer"
+	case reflect.Float32, reflect.Float64:
+		schema.Type = "number"
+	case reflect.String:
+		schema.Type = "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			schema.Type = "string"
+			schema.Format = "bytes"
+			break
+		}
+		schema.Type = "array"
+		schema.Items = typeSchema(t.Elem(), seen)
+	case reflect.Map:
+		schema.Type = "object"
+		schema.AdditionalProperties = typeSchema(t.Elem(), seen)
+	case reflect.Struct:
+		if t == typeOfTime {
+			schema.Type = "string"
+			schema.Format = "date-time"
+			break
+		}
+
+		schema.Type = "object"
+		if seen[t] {
+			schema.Ref = schema.Name
+			break
+		}
+		if seen == nil {
+			seen = make(map[reflect.Type]bool)
+		}
+		seen[t] = true
+		schema.Properties = make(map[string]*share.TypeSchema)
+		structSchema(t, schema.Properties, seen)
+		delete(seen, t)
+	default:
+		// interface, chan, func 等
+		schema.Type = "any"
+	}
+
+	return schema
+}
+
+func structSchema(t reflect.Type, properties map[string]*share.TypeSchema, seen map[reflect.Type]bool) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		// 和 encoding/json 一样展开没有命名的内嵌结构体
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			embedded = append(embedded, ft)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = typeSchema(f.Type, seen)
+	}
+
+	// 外层的字段优先于内嵌结构体的同名字段
+	for _, et := range embedded {
+		if seen[et] {
+			continue
+		}
+		seen[et] = true
+		fields := make(map[string]*share.TypeSchema)
+		structSchema(et, fields, seen)
+		delete(seen, et)
+
+		for name, fs := range fields {
+			if _, ok := properties[name]; !ok {
+				properties[name] = fs
+			}
+		}
+	}
+}
@@ -11,11 +11,13 @@ import (
 	"net/http"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/marsevilspirit/phobos/codec"
 	"github.com/marsevilspirit/phobos/log"
 	"github.com/marsevilspirit/phobos/protocol"
 	"github.com/marsevilspirit/phobos/share"
@@ -23,11 +25,17 @@ import (
 
 var ErrServerClosed = errors.New("http: Server closed")
 
+// errCanceledByClient is the cause of contexts canceled by a cancellation frame.
+var errCanceledByClient = errors.New("phobos: request canceled by client")
+
 const (
 	ReaderBufferSize = 1024
 	WriterBufferSize = 1024
 )
 
+// shutdownPollInterval is how often Shutdown polls for in-flight requests.
+const shutdownPollInterval = 100 * time.Millisecond
+
 type contextKey struct {
 	name string
 }
@@ -51,14 +59,43 @@ type Server struct {
 
 	mu         sync.Mutex
 	activeConn map[net.Conn]struct{}
-	done       chan struct{}
-	seq        uint64
 
-	// inShutdown int32
-	onShutdown []func()
+	// 每个连接上正在处理的请求, 用于响应客户端的取消帧
+	cancelMu    sync.Mutex
+	cancelFuncs map[net.Conn]map[uint64]context.CancelCauseFunc
+
+	streamMu sync.Mutex
+	streams  map[net.Conn]map[uint64]*serverStream
+
+	done chan struct{}
+	seq  uint64
+
+	inShutdown    int32
+	onShutdown    []func()
+	handlerMsgNum int32
 
 	tlsConfig *tls.Config
 
+	// 服务端默认的处理超时, key 为 servicePath 或 servicePath.serviceMethod
+	timeouts map[string]time.Duration
+
+	disableReflection bool
+
+	// 服务的健康状态, key 为空字符串时表示整个服务端
+	healthMu sync.RWMutex
+	health   map[string]share.ServingStatus
+	// Shutdown 在把状态改为 NOT_SERVING 之后等待的时间
+	drainDelay time.Duration
+
+	// 限制处理请求的 goroutine 数量, 为 nil 时每个请求一个 goroutine
+	workerPool *workerPool
+	// 每个连接上同时处理的请求数的上限, 达到上限时停止读取这个连接
+	maxConnInflight int
+	// 自适应并发限制, key 为 servicePath, 空字符串为默认的限制
+	adaptiveLimits map[string]*adaptiveLimiter
+
+	interceptors []Interceptor
+
 	options map[string]any
 
 	Plugins PluginContainer
@@ -158,6 +195,10 @@ func (s *Server) serveListener(ln net.Listener) error {
 	for {
 		conn, e := ln.Accept()
 		if e != nil {
+			if s.isShutdown() {
+				return ErrServerClosed
+			}
+
 			select {
 			case <-s.getDone():
 				return ErrServerClosed
@@ -183,6 +224,11 @@ func (s *Server) serveListener(ln net.Listener) error {
 		}
 		tempDelay = 0
 
+		if s.isShutdown() {
+			conn.Close()
+			continue
+		}
+
 		if tc, ok := conn.(*net.TCPConn); ok {
 			tc.SetKeepAlive(true)
 			tc.SetKeepAlivePeriod(3 * time.Minute)
@@ -224,6 +270,9 @@ func (s *Server) serveByHTTP(ln net.Listener, rpcPath string) {
 }
 
 func (s *Server) serveConn(conn net.Conn) {
+	// 正在处理中的请求，连接关闭前需要等待它们写完响应
+	var inflight sync.WaitGroup
+
 	defer func() {
 		if err := recover(); err != nil {
 			const size = 64 << 10
@@ -240,6 +289,10 @@ func (s *Server) serveConn(conn net.Conn) {
 			log.Errorf("serving %s panic error: %s, stack:\n %s", conn.RemoteAddr(), err, buf)
 		}
 
+		s.closeStreams(conn)
+		inflight.Wait()
+		s.clearCancels(conn)
+
 		s.mu.Lock()
 		delete(s.activeConn, conn)
 		s.mu.Unlock()
@@ -258,6 +311,9 @@ func (s *Server) serveConn(conn net.Conn) {
 		}
 	}
 
+	// 连接上正在处理的请求的信号量, 满了之后阻塞读取, 依靠 TCP 流控让客户端慢下来
+	connLimit := newConnLimiter(s.maxConnInflight)
+
 	ctx := context.WithValue(context.Background(), RemoteConnContextKey, conn)
 	r := bufio.NewReaderSize(conn, ReaderBufferSize)
 	for {
@@ -267,9 +323,16 @@ func (s *Server) serveConn(conn net.Conn) {
 			conn.SetReadDeadline(now.Add(s.readTimeout))
 		}
 
+		// Shutdown 会把读超时设置为当前时间，这里的检查必须放在设置读超时之后
+		if s.isShutdown() {
+			return
+		}
+
 		req, err := s.readRequest(ctx, r)
 		if err != nil {
-			if err == io.EOF {
+			if s.isShutdown() {
+				log.Infof("phobos: stop reading from %s, server is shutting down", conn.RemoteAddr().String())
+			} else if err == io.EOF {
 				log.Infof("client disconnected: %s", conn.RemoteAddr().String())
 			} else if strings.Contains(err.Error(), "use of closed network connection") {
 				log.Infof("rpcx: connection %s is closed", conn.RemoteAddr().String())
@@ -279,6 +342,17 @@ func (s *Server) serveConn(conn net.Conn) {
 			return
 		}
 
+		if req.IsCancel() {
+			s.cancelRequest(conn, req.Seq())
+			protocol.FreeMsg(req)
+			continue
+		}
+
+		if req.StreamFrameType() != protocol.StreamNone {
+			s.handleStreamFrame(ctx, conn, req, &inflight, connLimit)
+			continue
+		}
+
 		if s.writeTimeout != 0 {
 			conn.SetWriteDeadline(now.Add(s.writeTimeout))
 		}
@@ -299,25 +373,93 @@ func (s *Server) serveConn(conn net.Conn) {
 			continue
 		}
 
-		go func() {
-			if req.IsHeartbeat() {
+		atomic.AddInt32(&s.handlerMsgNum, 1)
+		inflight.Add(1)
+
+		if req.IsHeartbeat() {
+			go func() {
+				defer func() {
+					atomic.AddInt32(&s.handlerMsgNum, -1)
+					inflight.Done()
+				}()
+
 				req.SetMessageType(protocol.Response)
 				data := req.Encode()
 				conn.Write(data)
-				return
-			}
+			}()
+			continue
+		}
 
-			resMetadata := make(map[string]string)
-			newCtx := context.WithValue(context.WithValue(ctx, share.ReqMetaDataKey, req.Metadata), share.ResMetaDataKey, resMetadata)
+		// 流占满了连接的名额时拒绝请求, 而不是停止读取流的帧
+		hasSlot := connLimit.acquire()
+		overloaded := !hasSlot
 
-			res, err := s.handleRequest(newCtx, req)
+		// 在启动 goroutine 之前检查自适应并发限制, 超过限制时尽早拒绝
+		limiter := s.adaptiveLimiter(req.ServicePath)
+		if overloaded {
+			limiter = nil
+		}
+		if limiter != nil && !limiter.acquire() {
+			limiter = nil
+			overloaded = true
+		}
+
+		resMetadata := make(map[string]string)
+		newCtx := context.WithValue(context.WithValue(ctx, share.ReqMetaDataKey, req.Metadata), share.ResMetaDataKey, resMetadata)
+		newCtx, cancel := s.withTimeout(newCtx, req)
+		newCtx, cancelCause := context.WithCancelCause(newCtx)
+
+		// 在启动 goroutine 之前注册, 保证随后读到的取消帧一定能找到这个请求
+		seq := req.Seq()
+		s.registerCancel(conn, seq, cancelCause)
+
+		handle := func(overloaded bool) {
+			defer func() {
+				s.unregisterCancel(conn, seq)
+				cancelCause(nil)
+				cancel()
+				atomic.AddInt32(&s.handlerMsgNum, -1)
+				inflight.Done()
+				if hasSlot {
+					connLimit.release()
+				}
+			}()
+
+			var res *protocol.Message
+			var err error
+			var rtt time.Duration
+			if limiter != nil {
+				defer func() {
+					limiter.release(rtt)
+				}()
+			}
+			if overloaded {
+				res = req.Clone()
+				res.SetMessageType(protocol.Response)
+				res, err = handleError(res, ErrServerOverloaded)
+			} else if ctxErr := newCtx.Err(); ctxErr != nil {
+				// 调用方的时间预算已经耗尽，没有必要再执行
+				res = req.Clone()
+				res.SetMessageType(protocol.Response)
+				res, err = handleError(res, ctxErr)
+			} else if err = doPreHandleRequest(s.Plugins, newCtx, req); err != nil {
+				res = req.Clone()
+				res.SetMessageType(protocol.Response)
+				res, err = handleError(res, err)
+			} else {
+				start := time.Now()
+				res, err = s.handleRequest(newCtx, req)
+				rtt = time.Since(start)
+				doPostHandleRequest(s.Plugins, newCtx, req, res, err)
+			}
 			if err != nil {
 				log.Warnf("phobos: failed to handle request: %v", err)
 			}
 
 			s.Plugins.DoPreWriteResponse(newCtx, req)
 
-			if !req.IsOneway() {
+			// 客户端已经放弃了这个请求, 不再需要响应
+			if !req.IsOneway() && context.Cause(newCtx) != errCanceledByClient {
 				if len(resMetadata) > 0 {
 					meta := res.Metadata
 					if meta == nil {
@@ -337,10 +479,61 @@ func (s *Server) serveConn(conn net.Conn) {
 
 			protocol.FreeMsg(req)
 			protocol.FreeMsg(res)
-		}()
+		}
+
+		if overloaded {
+			handle(true)
+			continue
+		}
+		if s.workerPool == nil {
+			go handle(false)
+			continue
+		}
+		if !s.workerPool.submit(func() { handle(false) }) {
+			// 队列已满, 直接在读循环里返回过载错误
+			handle(true)
+		}
+	}
+}
+
+func (s *Server) registerCancel(conn net.Conn, seq uint64, cancel context.CancelCauseFunc) {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+
+	if s.cancelFuncs == nil {
+		s.cancelFuncs = make(map[net.Conn]map[uint64]context.CancelCauseFunc)
+	}
+	m := s.cancelFuncs[conn]
+	if m == nil {
+		m = make(map[uint64]context.CancelCauseFunc)
+		s.cancelFuncs[conn] = m
+	}
+	m[seq] = cancel
+}
+
+func (s *Server) unregisterCancel(conn net.Conn, seq uint64) {
+	s.cancelMu.Lock()
+	delete(s.cancelFuncs[conn], seq)
+	s.cancelMu.Unlock()
+}
+
+// cancelRequest cancels the context of the in-flight request seq on conn.
+func (s *Server) cancelRequest(conn net.Conn, seq uint64) {
+	s.cancelMu.Lock()
+	cancel := s.cancelFuncs[conn][seq]
+	s.cancelMu.Unlock()
+
+	if cancel != nil {
+		cancel(errCanceledByClient)
 	}
 }
 
+func (s *Server) clearCancels(conn net.Conn) {
+	s.cancelMu.Lock()
+	delete(s.cancelFuncs, conn)
+	s.cancelMu.Unlock()
+}
+
 func (s *Server) readRequest(ctx context.Context, r io.Reader) (req *protocol.Message, err error) {
 	s.Plugins.DoPreReadRequest(ctx)
 
@@ -352,6 +545,39 @@ func (s *Server) readRequest(ctx context.Context, r io.Reader) (req *protocol.Me
 	return req, err
 }
 
+// withTimeout derives the context of a service call. The deadline is the
+// earlier one of the caller's remaining budget and the server default timeout
+// of the method.
+func (s *Server) withTimeout(ctx context.Context, req *protocol.Message) (context.Context, context.CancelFunc) {
+	var timeout time.Duration
+
+	if v := req.Metadata[share.TimeoutKey]; v != "" {
+		if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+			timeout = time.Duration(ms) * time.Millisecond
+			if timeout <= 0 {
+				// 已经超时, 使用一个最小的正值以便立即触发 ctx.Done()
+				timeout = time.Nanosecond
+			}
+		} else {
+			log.Warnf("phobos: invalid timeout %q from %s.%s", v, req.ServicePath, req.ServiceMethod)
+		}
+	}
+
+	d, ok := s.timeouts[req.ServicePath+"."+req.ServiceMethod]
+	if !ok {
+		d = s.timeouts[req.ServicePath]
+	}
+	if d > 0 && (timeout == 0 || d < timeout) {
+		timeout = d
+	}
+
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
 func (s *Server) auth(ctx context.Context, req *protocol.Message) (err error) {
 	// 验证身份
 	if s.AuthFunc != nil {
@@ -369,6 +595,22 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
 	res = req.Clone()
 	res.SetMessageType(protocol.Response)
 
+	// 内置服务不在 serviceMap 中
+	var builtin map[string]*handlerType
+	switch {
+	case serviceName == share.HealthServicePath:
+		builtin = s.healthService()
+	case serviceName == share.ReflectionServicePath && !s.disableReflection:
+		builtin = s.reflectionService()
+	}
+	if builtin != nil {
+		h := builtin[methodName]
+		if h == nil {
+			return handleError(res, errors.New("phobos: can't find method "+methodName))
+		}
+		return s.handleRequestForHandler(ctx, req, res, h)
+	}
+
 	s.serviceMapMu.RLock()
 	service := s.serviceMap[serviceName]
 	s.serviceMapMu.RUnlock()
@@ -376,6 +618,9 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
 		err = errors.New("phobos: can't find service " + serviceName)
 		return handleError(res, err)
 	}
+	if h := service.handler[methodName]; h != nil {
+		return s.handleRequestForHandler(ctx, req, res, h)
+	}
 	mtype := service.method[methodName]
 	if mtype == nil {
 		if service.function[methodName] != nil {
@@ -400,24 +645,14 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
 
 	replyv := argsReplyPools.Get(mtype.ReplyType)
 
-	err = service.call(ctx, mtype, reflect.ValueOf(argv), reflect.ValueOf(replyv))
-	argsReplyPools.Put(mtype.ArgType, argv)
-	if err != nil {
-		argsReplyPools.Put(mtype.ReplyType, replyv)
-		return handleError(res, err)
-	}
-
-	if !req.IsOneway() {
-		data, err := codec.Encode(replyv)
-		argsReplyPools.Put(mtype.ReplyType, replyv)
-		if err != nil {
-			return handleError(res, err)
-
+	info := &RequestInfo{ServicePath: serviceName, ServiceMethod: methodName, Args: argv}
+	reply, err := s.intercept(ctx, info, func(ctx context.Context, info *RequestInfo) (any, error) {
+		if err := service.call(ctx, mtype, reflect.ValueOf(info.Args), reflect.ValueOf(replyv)); err != nil {
+			return nil, err
 		}
-		res.Payload = data
-	}
-
-	return res, nil
+		return replyv, nil
+	})
+	return s.writeReply(req, res, codec, mtype.ArgType, mtype.ReplyType, argv, replyv, reply, err)
 }
 
 func (s *Server) handleRequestForFunction(ctx context.Context, req *protocol.Message) (res *protocol.Message, err error) {
@@ -458,16 +693,32 @@ func (s *Server) handleRequestForFunction(ctx context.Context, req *protocol.Mes
 
 	replyv := argsReplyPools.Get(mtype.ReplyType)
 
-	err = service.callForFunction(ctx, mtype, reflect.ValueOf(argv), reflect.ValueOf(replyv))
-	argsReplyPools.Put(mtype.ArgType, argv)
+	info := &RequestInfo{ServicePath: serviceName, ServiceMethod: methodName, Args: argv}
+	reply, err := s.intercept(ctx, info, func(ctx context.Context, info *RequestInfo) (any, error) {
+		if err := service.callForFunction(ctx, mtype, reflect.ValueOf(info.Args), reflect.ValueOf(replyv)); err != nil {
+			return nil, err
+		}
+		return replyv, nil
+	})
+	return s.writeReply(req, res, codec, mtype.ArgType, mtype.ReplyType, argv, replyv, reply, err)
+}
+
+// writeReply encodes the reply returned by the interceptors into res, and
+// puts argv and replyv back to the pools.
+func (s *Server) writeReply(req, res *protocol.Message, cc codec.Codec, argType, replyType reflect.Type, argv, replyv, reply any, err error) (*protocol.Message, error) {
+	// 拦截器可能持有参数和结果, 这时不放回对象池
+	pooled := len(s.interceptors) == 0
+	if pooled {
+		argsReplyPools.Put(argType, argv)
+		defer argsReplyPools.Put(replyType, replyv)
+	}
+
 	if err != nil {
-		argsReplyPools.Put(mtype.ReplyType, replyv)
 		return handleError(res, err)
 	}
 
-	if !req.IsOneway() {
-		data, err := codec.Encode(replyv)
-		argsReplyPools.Put(mtype.ReplyType, replyv)
+	if !req.IsOneway() && reply != nil {
+		data, err := cc.Encode(reply)
 		if err != nil {
 			return handleError(res, err)
 		}
@@ -528,6 +779,7 @@ func (s *Server) Close() error {
 	return err
 }
 
+// RegisterOnShutdown registers a function to call on Shutdown.
 // 优雅关闭连接
 func (s *Server) RegisterOnShutdown(f func()) {
 	s.mu.Lock()
@@ -535,6 +787,91 @@ func (s *Server) RegisterOnShutdown(f func()) {
 	s.mu.Unlock()
 }
 
+// Shutdown gracefully shuts down the server without interrupting any
+// in-flight requests. It first reports NOT_SERVING by the health service and
+// waits for the delay set by WithDrainDelay, so clients checking the health of
+// the server can stop sending requests to it. Then it stops accepting new
+// connections, stops reading new requests from existing connections, waits
+// for the running handlers to write their responses, runs the functions
+// registered by RegisterOnShutdown and finally closes all connections.
+//
+// If ctx expires before the in-flight requests finish, Shutdown still runs
+// the hooks and closes the connections, and returns ctx.Err().
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.isShutdown() {
+		return ErrServerClosed
+	}
+
+	s.SetServingStatus("", share.NotServing)
+	if s.drainDelay > 0 {
+		t := time.NewTimer(s.drainDelay)
+		select {
+		case <-ctx.Done():
+		case <-t.C:
+		}
+		t.Stop()
+	}
+
+	if !atomic.CompareAndSwapInt32(&s.inShutdown, 0, 1) {
+		return ErrServerClosed
+	}
+
+	log.Info("phobos: shutdown begin")
+
+	s.mu.Lock()
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	// 让阻塞在读请求上的连接立即返回，不再读取新的请求
+	for conn := range s.activeConn {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	var err error
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+outer:
+	for !s.checkProcessMsg() {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break outer
+		case <-ticker.C:
+		}
+	}
+
+	s.mu.Lock()
+	onShutdown := s.onShutdown
+	s.mu.Unlock()
+	for _, f := range onShutdown {
+		f()
+	}
+
+	s.mu.Lock()
+	for conn := range s.activeConn {
+		conn.Close()
+		delete(s.activeConn, conn)
+	}
+	s.closeDoneLocked()
+	s.mu.Unlock()
+
+	log.Info("phobos: shutdown end")
+
+	return err
+}
+
+func (s *Server) isShutdown() bool {
+	return atomic.LoadInt32(&s.inShutdown) == 1
+}
+
+// checkProcessMsg reports whether all in-flight requests have been handled.
+func (s *Server) checkProcessMsg() bool {
+	size := atomic.LoadInt32(&s.handlerMsgNum)
+	log.Debugf("phobos: %d requests are still in process", size)
+	return size == 0
+}
+
 func (s *Server) closeDoneLocked() {
 	ch := s.getDoneLocked()
 	select {
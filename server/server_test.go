@@ -3,7 +3,10 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"net"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/marsevilspirit/phobos/protocol"
 	"github.com/marsevilspirit/phobos/share"
@@ -80,3 +83,186 @@ func TestHandleRequest(t *testing.T) {
 		t.Fatalf("expect 200 but got %d", reply.C)
 	}
 }
+
+type Slow struct {
+	started chan struct{}
+}
+
+func (t *Slow) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	close(t.started)
+	time.Sleep(200 * time.Millisecond)
+	reply.C = args.A * args.B
+	return nil
+}
+
+func TestShutdown(t *testing.T) {
+	slow := &Slow{started: make(chan struct{})}
+
+	s := NewServer()
+	s.RegisterWithName("Slow", slow, "")
+
+	var hookCalled int32
+	s.RegisterOnShutdown(func() {
+		atomic.StoreInt32(&hookCalled, 1)
+	})
+
+	go s.Serve("tcp", "127.0.0.1:0")
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.SetSeq(1)
+	req.ServicePath = "Slow"
+	req.ServiceMethod = "Mul"
+	req.Payload, _ = json.Marshal(&Args{A: 10, B: 20})
+	if _, err := conn.Write(req.Encode()); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+
+	<-slow.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to shutdown: %v", err)
+	}
+
+	if atomic.LoadInt32(&hookCalled) != 1 {
+		t.Fatal("expect onShutdown hook to be called")
+	}
+
+	res, err := protocol.Read(conn)
+	if err != nil {
+		t.Fatalf("in-flight request was dropped: %v", err)
+	}
+
+	reply := &Reply{}
+	if err := json.Unmarshal(res.Payload, reply); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if reply.C != 200 {
+		t.Fatalf("expect 200 but got %d", reply.C)
+	}
+
+	if _, err := net.Dial("tcp", s.Address().String()); err == nil {
+		t.Fatal("expect server to stop accepting connections")
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	s := NewServer(WithServiceTimeout("Arith", time.Second), WithMethodTimeout("Arith", "Mul", 10*time.Millisecond))
+
+	req := protocol.NewMessage()
+	req.ServicePath = "Arith"
+	req.ServiceMethod = "Mul"
+
+	ctx, cancel := s.withTimeout(context.Background(), req)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > 10*time.Millisecond {
+		t.Fatalf("expect method timeout to be applied, got %v", deadline)
+	}
+
+	req.ServiceMethod = "Add"
+	req.Metadata[share.TimeoutKey] = "50"
+	ctx, cancel = s.withTimeout(context.Background(), req)
+	defer cancel()
+	deadline, ok = ctx.Deadline()
+	if !ok || time.Until(deadline) > 50*time.Millisecond {
+		t.Fatalf("expect caller timeout to be applied, got %v", deadline)
+	}
+}
+
+type rejectPlugin struct {
+	post int32
+}
+
+func (p *rejectPlugin) PreHandleRequest(ctx context.Context, r *protocol.Message) error {
+	if r.ServiceMethod == "Mul" {
+		return ErrServerOverloaded
+	}
+	return nil
+}
+
+func (p *rejectPlugin) PostHandleRequest(ctx context.Context, r *protocol.Message, res *protocol.Message, e error) {
+	atomic.AddInt32(&p.post, 1)
+}
+
+func TestPreHandleRequestPlugin(t *testing.T) {
+	s := NewServer()
+	s.RegisterWithName("Arith", new(Arith), "")
+	p := &rejectPlugin{}
+	s.Plugins.Add(p)
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	writeMulRequest(t, conn, "Arith", 1)
+	res, err := protocol.Read(conn)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if e := res.Metadata[protocol.ServiceError]; e != ErrServerOverloaded.Error() {
+		t.Fatalf("expect request to be rejected, got %q", e)
+	}
+	if n := atomic.LoadInt32(&p.post); n != 0 {
+		t.Fatalf("expect PostHandleRequest not to be invoked for a rejected request, got %d", n)
+	}
+}
+
+type acceptPlugin struct {
+	post int32
+}
+
+func (p *acceptPlugin) PreHandleRequest(ctx context.Context, r *protocol.Message) error {
+	return nil
+}
+
+func (p *acceptPlugin) PostHandleRequest(ctx context.Context, r *protocol.Message, res *protocol.Message, e error) {
+	atomic.AddInt32(&p.post, 1)
+}
+
+// basicContainer hides the methods of the container outside PluginContainer.
+type basicContainer struct {
+	PluginContainer
+}
+
+func TestDoPreHandleRequest(t *testing.T) {
+	for _, plugins := range []PluginContainer{&pluginContainer{}, basicContainer{&pluginContainer{}}} {
+		first, second := &acceptPlugin{}, &rejectPlugin{}
+		plugins.Add(first)
+		plugins.Add(second)
+
+		req := protocol.NewMessage()
+		req.ServiceMethod = "Mul"
+		if err := doPreHandleRequest(plugins, context.Background(), req); err != ErrServerOverloaded {
+			t.Fatalf("expect the request to be rejected by %T, got %v", plugins, err)
+		}
+		// 只有拒绝之前的插件会收到 PostHandleRequest
+		if atomic.LoadInt32(&first.post) != 1 || atomic.LoadInt32(&second.post) != 0 {
+			t.Fatalf("expect PostHandleRequest only for the plugins before the rejecting one, got %d, %d", first.post, second.post)
+		}
+
+		req.ServiceMethod = "Add"
+		if err := doPreHandleRequest(plugins, context.Background(), req); err != nil {
+			t.Fatalf("expect the request to be accepted by %T, got %v", plugins, err)
+		}
+		doPostHandleRequest(plugins, context.Background(), req, nil, nil)
+		if atomic.LoadInt32(&first.post) != 2 || atomic.LoadInt32(&second.post) != 1 {
+			t.Fatalf("expect PostHandleRequest to be invoked by %T, got %d, %d", plugins, first.post, second.post)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"go/ast"
+	"maps"
 	"reflect"
 	"runtime"
 	"strings"
@@ -38,6 +39,9 @@ type service struct {
 	typ      reflect.Type           // type of the receiver
 	method   map[string]*methodType // 注册方法
 	function map[string]*functionType
+	handler  map[string]*handlerType  // 通过 RegisterHandler 注册的方法
+	stream   map[string]StreamHandler // 流式方法
+	metadata string                   // 注册时传入的元数据
 }
 
 func isExportedOrBuiltinType(t reflect.Type) bool {
@@ -52,7 +56,7 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 
 func (s *Server) Register(rcvr any, metadata string) error {
 	s.Plugins.DoRegister("", rcvr, metadata)
-	return s.register(rcvr, "", false)
+	return s.register(rcvr, "", metadata, false)
 }
 
 func (s *Server) RegisterWithName(name string, rcvr any, metadata string) error {
@@ -60,7 +64,7 @@ func (s *Server) RegisterWithName(name string, rcvr any, metadata string) error
 		s.Plugins = &pluginContainer{}
 	}
 	s.Plugins.DoRegister(name, rcvr, metadata)
-	return s.register(rcvr, name, true)
+	return s.register(rcvr, name, metadata, true)
 }
 
 func (s *Server) RegisterFunction(servicePath string, fn any, metadata string) error {
@@ -76,7 +80,7 @@ func (s *Server) RegisterFunctionWithName(servicePath string, name string, fn an
 	return s.registerFunction(servicePath, name, fn, true)
 }
 
-func (s *Server) register(rcvr any, name string, useName bool) error {
+func (s *Server) register(rcvr any, name, metadata string, useName bool) error {
 	s.serviceMapMu.Lock()
 	defer s.serviceMapMu.Unlock()
 
@@ -121,6 +125,15 @@ func (s *Server) register(rcvr any, name string, useName bool) error {
 		log.Error(errorStr)
 		return errors.New(errorStr)
 	}
+	service.metadata = metadata
+	// 保留之前通过 RegisterHandler 和 RegisterStream 注册的方法
+	if old := s.serviceMap[service.name]; old != nil {
+		service.handler = old.handler
+		service.stream = old.stream
+		if metadata == "" {
+			service.metadata = old.metadata
+		}
+	}
 	s.serviceMap[service.name] = service
 	return nil
 }
@@ -132,10 +145,9 @@ func (s *Server) registerFunction(servicePath string, name string, fn any, useNa
 		s.serviceMap = make(map[string]*service)
 	}
 
-	ss := s.serviceMap[servicePath]
-	if ss == nil {
-		ss = new(service)
-		ss.name = servicePath
+	ss, _ := s.copyServiceLocked(servicePath)
+	ss.function = maps.Clone(ss.function)
+	if ss.function == nil {
 		ss.function = make(map[string]*functionType)
 	}
 
@@ -2,9 +2,14 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"reflect"
+	"strconv"
 	"testing"
+
+	"github.com/marsevilspirit/phobos/protocol"
+	"github.com/marsevilspirit/phobos/share"
 )
 
 type TestService struct{}
@@ -87,3 +92,177 @@ func TestMethodWithEmptyName(t *testing.T) {
 		t.Fatalf("Expected error 'name is empty', but got %v", err)
 	}
 }
+
+func TestRegisterHandler(t *testing.T) {
+	server := &Server{}
+	err := server.RegisterWithName("TestService", &TestService{}, "")
+	if err != nil {
+		t.Fatalf("Failed to register service: %v", err)
+	}
+
+	// 与反射注册的方法共存于同一个服务
+	err = RegisterHandler(server, "TestService", "Greet", func(ctx context.Context, arg *TestArg) (*TestReply, error) {
+		if arg.Name == "" {
+			return nil, errors.New("name is empty")
+		}
+		return &TestReply{Message: "Hi, " + arg.Name}, nil
+	}, "")
+	if err != nil {
+		t.Fatalf("Failed to register handler: %v", err)
+	}
+
+	call := func(method string, arg *TestArg) (*TestReply, error) {
+		req := protocol.NewMessage()
+		req.SetMessageType(protocol.Request)
+		req.SetSerializeType(protocol.JSON)
+		req.ServicePath = "TestService"
+		req.ServiceMethod = method
+		req.Payload, _ = json.Marshal(arg)
+
+		res, err := server.handleRequest(context.Background(), req)
+		if err != nil {
+			return nil, err
+		}
+		reply := &TestReply{}
+		if err := json.Unmarshal(res.Payload, reply); err != nil {
+			t.Fatalf("Failed to decode reply: %v", err)
+		}
+		return reply, nil
+	}
+
+	reply, err := call("Greet", &TestArg{Name: "World"})
+	if err != nil {
+		t.Fatalf("Handler call failed: %v", err)
+	}
+	if reply.Message != "Hi, World" {
+		t.Fatalf("Expected message 'Hi, World', but got %v", reply.Message)
+	}
+
+	reply, err = call("TestMethod", &TestArg{Name: "World"})
+	if err != nil {
+		t.Fatalf("Method call failed: %v", err)
+	}
+	if reply.Message != "Hello, World" {
+		t.Fatalf("Expected message 'Hello, World', but got %v", reply.Message)
+	}
+
+	if _, err = call("Greet", &TestArg{}); err == nil || err.Error() != "name is empty" {
+		t.Fatalf("Expected error 'name is empty', but got %v", err)
+	}
+
+	// 反射方式重新注册服务时不能丢失已注册的 handler
+	if err := server.RegisterWithName("TestService", &TestService{}, ""); err != nil {
+		t.Fatalf("Failed to register service: %v", err)
+	}
+	if _, err = call("Greet", &TestArg{Name: "World"}); err != nil {
+		t.Fatalf("Handler call failed after re-register: %v", err)
+	}
+}
+
+func TestRegisterWhileServing(t *testing.T) {
+	server := &Server{}
+	if err := server.RegisterWithName("TestService", &TestService{}, ""); err != nil {
+		t.Fatalf("Failed to register service: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			method := "Greet" + strconv.Itoa(i)
+			RegisterHandler(server, "TestService", method, func(ctx context.Context, arg *TestArg) (*TestReply, error) {
+				return &TestReply{}, nil
+			}, "")
+			server.RegisterStream("TestService", method+"Stream", func(ctx context.Context, stream Stream) error {
+				return nil
+			}, "")
+		}
+	}()
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath = "TestService"
+	req.ServiceMethod = "TestMethod"
+	req.Payload, _ = json.Marshal(&TestArg{Name: "World"})
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if _, err := server.handleRequest(context.Background(), req); err != nil {
+			t.Fatalf("Method call failed: %v", err)
+		}
+	}
+}
+
+type Node struct {
+	Value    int               `json:"value"`
+	Children []*Node           `json:"children,omitempty"`
+	Tags     map[string]string `json:"-"`
+}
+
+func TestReflection(t *testing.T) {
+	server := &Server{}
+	if err := server.RegisterWithName("TestService", &TestService{}, "group=test"); err != nil {
+		t.Fatalf("Failed to register service: %v", err)
+	}
+	RegisterHandler(server, "Tree", "Sum", func(ctx context.Context, n *Node) (*TestReply, error) {
+		return &TestReply{}, nil
+	}, "")
+	server.RegisterStream("Tree", "Walk", func(ctx context.Context, stream Stream) error {
+		return nil
+	}, "")
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.ServicePath = share.ReflectionServicePath
+	req.ServiceMethod = share.ReflectionListServices
+	req.Payload, _ = json.Marshal(&share.ListServicesArgs{})
+
+	res, err := server.handleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Failed to list services: %v", err)
+	}
+	reply := &share.ListServicesReply{}
+	if err := json.Unmarshal(res.Payload, reply); err != nil {
+		t.Fatalf("Failed to decode reply: %v", err)
+	}
+
+	if len(reply.Services) != 2 || reply.Services[0].Name != "TestService" || reply.Services[1].Name != "Tree" {
+		t.Fatalf("Unexpected services: %+v", reply.Services)
+	}
+
+	svc := reply.Services[0]
+	if svc.Metadata != "group=test" {
+		t.Fatalf("Expected metadata 'group=test', but got %q", svc.Metadata)
+	}
+	if len(svc.Methods) != 1 || svc.Methods[0].Name != "TestMethod" || svc.Methods[0].Kind != share.MethodKindUnary {
+		t.Fatalf("Unexpected methods: %+v", svc.Methods)
+	}
+	arg := svc.Methods[0].ArgType
+	if arg.Name != "server.TestArg" || arg.Type != "object" || arg.Properties["Name"].Type != "string" {
+		t.Fatalf("Unexpected arg type: %+v", arg)
+	}
+
+	tree := reply.Services[1]
+	if len(tree.Methods) != 2 || tree.Methods[1].Name != "Walk" || tree.Methods[1].Kind != share.MethodKindStream {
+		t.Fatalf("Unexpected methods: %+v", tree.Methods)
+	}
+	node := tree.Methods[0].ArgType
+	if _, ok := node.Properties["Tags"]; ok {
+		t.Fatal("Expected field with json tag '-' to be skipped")
+	}
+	children := node.Properties["children"]
+	if children == nil || children.Type != "array" || children.Items.Ref != "server.Node" {
+		t.Fatalf("Unexpected schema of recursive field: %+v", children)
+	}
+
+	// 关闭反射服务
+	server.disableReflection = true
+	if _, err := server.handleRequest(context.Background(), req); err == nil {
+		t.Fatal("Expected an error when reflection is disabled")
+	}
+}
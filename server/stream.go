@@ -0,0 +1,394 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/marsevilspirit/phobos/codec"
+	"github.com/marsevilspirit/phobos/log"
+	"github.com/marsevilspirit/phobos/protocol"
+	"github.com/marsevilspirit/phobos/share"
+	"github.com/marsevilspirit/phobos/util"
+)
+
+var (
+	ErrStreamOverflow = errors.New("phobos: stream peer does not respect flow control")
+	errConnClosed     = errors.New("phobos: connection closed")
+)
+
+// Stream is a bidirectional stream between a client and a StreamHandler.
+// Server-streaming methods only Send, client-streaming methods only Recv
+// and bidirectional methods do both.
+//
+// It is safe to call Send and Recv from different goroutines, but it is not
+// safe to call Recv (or Send) from multiple goroutines at the same time.
+type Stream interface {
+	// Context returns the context of the stream. It is canceled when the client
+	// abandons the stream, the deadline of the client expires or the
+	// connection is closed.
+	Context() context.Context
+	// Send sends v to the client. It blocks if the client does not read fast enough.
+	Send(v any) error
+	// Recv receives the next message from the client into v.
+	// It returns io.EOF once the client has called CloseSend.
+	Recv(v any) error
+}
+
+// StreamHandler handles a stream. The stream ends when the handler returns,
+// the returned error is sent to the client.
+type StreamHandler func(ctx context.Context, stream Stream) error
+
+type streamFrame struct {
+	payload    []byte
+	compressed bool
+}
+
+type serverStream struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	conn   net.Conn
+
+	seq           uint64
+	serializeType protocol.SerializeType
+	compressType  protocol.CompressType
+	codec         codec.Codec
+
+	window *protocol.StreamWindow
+
+	// recvCh 只由连接的读 goroutine 写入和关闭
+	recvCh     chan streamFrame
+	recvErr    error
+	recvClosed bool
+	consumed   int
+}
+
+func (st *serverStream) Context() context.Context {
+	return st.ctx
+}
+
+func (st *serverStream) Send(v any) error {
+	data, err := st.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+
+	compressed := false
+	if len(data) > 1024 && st.compressType == protocol.Gzip {
+		data, err = util.Zip(data)
+		if err != nil {
+			return err
+		}
+		compressed = true
+	}
+
+	if err := st.window.Acquire(st.ctx); err != nil {
+		return err
+	}
+
+	return st.writeFrame(protocol.StreamData, nil, data, compressed)
+}
+
+func (st *serverStream) Recv(v any) error {
+	select {
+	case f, ok := <-st.recvCh:
+		if !ok {
+			return st.recvErr
+		}
+
+		st.consumed++
+		if st.consumed >= protocol.DefaultStreamWindow/2 {
+			n := st.consumed
+			st.consumed = 0
+			if err := st.writeFrame(protocol.StreamWindowUpdate, nil, protocol.EncodeWindowUpdate(uint32(n)), false); err != nil {
+				return err
+			}
+		}
+
+		data := f.payload
+		if f.compressed {
+			var err error
+			data, err = util.Unzip(data)
+			if err != nil {
+				return err
+			}
+		}
+
+		return st.codec.Decode(data, v)
+	case <-st.ctx.Done():
+		return st.ctx.Err()
+	}
+}
+
+func (st *serverStream) writeFrame(t protocol.StreamFrameType, metadata map[string]string, payload []byte, compressed bool) error {
+	res := protocol.GetPoolMsg()
+	res.SetMessageType(protocol.Response)
+	res.SetSeq(st.seq)
+	res.SetSerializeType(st.serializeType)
+	res.SetStreamFrameType(t)
+	if compressed {
+		res.SetCompressType(protocol.Gzip)
+	}
+	if t == protocol.StreamError {
+		res.SetMessageStatusType(protocol.Error)
+	}
+	res.Metadata = metadata
+	res.Payload = payload
+
+	data := res.Encode()
+	protocol.FreeMsg(res)
+
+	_, err := st.conn.Write(data)
+	return err
+}
+
+// push is called by the reader goroutine of the connection.
+func (st *serverStream) push(f streamFrame) {
+	if st.recvClosed {
+		return
+	}
+
+	select {
+	case st.recvCh <- f:
+	default:
+		// 对端没有遵守流控, 终止这个流
+		st.closeRecv(ErrStreamOverflow)
+		st.cancel(ErrStreamOverflow)
+	}
+}
+
+// closeRecv is called by the reader goroutine of the connection.
+func (st *serverStream) closeRecv(err error) {
+	if st.recvClosed {
+		return
+	}
+
+	st.recvErr = err
+	st.recvClosed = true
+	close(st.recvCh)
+}
+
+// RegisterStream registers handler as the stream method servicePath.serviceMethod.
+// A service can have both unary methods registered by Register and stream methods.
+func (s *Server) RegisterStream(servicePath, serviceMethod string, handler StreamHandler, metadata string) error {
+	if servicePath == "" || serviceMethod == "" {
+		return errors.New("phobos.RegisterStream: servicePath and serviceMethod can't be empty")
+	}
+	if handler == nil {
+		return errors.New("phobos.RegisterStream: handler is nil")
+	}
+
+	s.serviceMapMu.Lock()
+	ss, isNew := s.copyServiceLocked(servicePath)
+	ss.stream = maps.Clone(ss.stream)
+	if ss.stream == nil {
+		ss.stream = make(map[string]StreamHandler)
+	}
+	ss.stream[serviceMethod] = handler
+	if metadata != "" {
+		ss.metadata = metadata
+	}
+	s.serviceMap[servicePath] = ss
+	s.serviceMapMu.Unlock()
+
+	if s.Plugins == nil {
+		s.Plugins = &pluginContainer{}
+	}
+	// 只在服务第一次出现时通知插件, 避免重复注册到注册中心
+	if isNew {
+		return s.Plugins.DoRegister(servicePath, handler, metadata)
+	}
+
+	return nil
+}
+
+// handleStreamFrame handles a stream frame read from conn.
+// It runs in the reader goroutine of conn.
+func (s *Server) handleStreamFrame(ctx context.Context, conn net.Conn, req *protocol.Message, inflight *sync.WaitGroup, connLimit *connLimiter) {
+	if req.StreamFrameType() == protocol.StreamOpen {
+		s.openStream(ctx, conn, req, inflight, connLimit)
+		return
+	}
+	defer protocol.FreeMsg(req)
+
+	st := s.getStream(conn, req.Seq())
+	if st == nil {
+		// 流已经结束
+		return
+	}
+
+	switch req.StreamFrameType() {
+	case protocol.StreamData:
+		st.push(streamFrame{payload: req.Payload, compressed: req.CompressType() == protocol.Gzip})
+	case protocol.StreamEnd:
+		st.closeRecv(io.EOF)
+	case protocol.StreamWindowUpdate:
+		st.window.Release(int(protocol.DecodeWindowUpdate(req.Payload)))
+	default:
+		log.Warnf("phobos: unexpected stream frame %d from %s", req.StreamFrameType(), conn.RemoteAddr().String())
+	}
+}
+
+// openStream starts the handler of the stream opened by req, req is freed
+// after the stream ends.
+func (s *Server) openStream(ctx context.Context, conn net.Conn, req *protocol.Message, inflight *sync.WaitGroup, connLimit *connLimiter) {
+	st := &serverStream{
+		conn:          conn,
+		seq:           req.Seq(),
+		serializeType: req.SerializeType(),
+		compressType:  req.CompressType(),
+		window:        protocol.NewStreamWindow(protocol.DefaultStreamWindow),
+		recvCh:        make(chan streamFrame, protocol.DefaultStreamWindow),
+	}
+
+	newCtx := context.WithValue(ctx, share.ReqMetaDataKey, req.Metadata)
+	limiter := s.adaptiveLimiter(req.ServicePath)
+	handler, err := s.lookupStream(req)
+	if err == nil {
+		err = s.auth(ctx, req)
+	}
+	if err == nil {
+		st.codec = share.Codecs[st.serializeType]
+		if st.codec == nil {
+			err = fmt.Errorf("can not find codec for %d", st.serializeType)
+		}
+	}
+	// 流在整个生命周期内占用连接的一个名额
+	if err == nil && !connLimit.acquireStream() {
+		err = ErrServerOverloaded
+	} else if err == nil {
+		// 与普通请求一样经过自适应限流和插件, 例如 LimitPlugin 的限流
+		if limiter != nil && !limiter.acquire() {
+			err = ErrServerOverloaded
+		} else if err = doPreHandleRequest(s.Plugins, newCtx, req); err != nil && limiter != nil {
+			limiter.release(0)
+		}
+		if err != nil {
+			connLimit.releaseStream()
+		}
+	}
+	if err != nil {
+		st.writeFrame(protocol.StreamError, map[string]string{protocol.ServiceError: err.Error()}, nil, false)
+		protocol.FreeMsg(req)
+		return
+	}
+
+	newCtx, cancel := s.withTimeout(newCtx, req)
+	st.ctx, st.cancel = context.WithCancelCause(newCtx)
+
+	s.registerCancel(conn, st.seq, st.cancel)
+	s.registerStream(conn, st.seq, st)
+
+	atomic.AddInt32(&s.handlerMsgNum, 1)
+	inflight.Add(1)
+	go func() {
+		var err error
+		defer func() {
+			s.unregisterStream(conn, st.seq)
+			s.unregisterCancel(conn, st.seq)
+			st.window.Close()
+			doPostHandleRequest(s.Plugins, st.ctx, req, nil, err)
+			st.cancel(nil)
+			cancel()
+			// 流的时长取决于客户端, 不作为延迟的样本
+			if limiter != nil {
+				limiter.release(0)
+			}
+			connLimit.releaseStream()
+			protocol.FreeMsg(req)
+			atomic.AddInt32(&s.handlerMsgNum, -1)
+			inflight.Done()
+		}()
+
+		err = callStreamHandler(handler, st)
+		if context.Cause(st.ctx) == errCanceledByClient {
+			return
+		}
+
+		if err != nil {
+			log.Warnf("phobos: stream %s.%s failed: %v", req.ServicePath, req.ServiceMethod, err)
+			st.writeFrame(protocol.StreamError, map[string]string{protocol.ServiceError: err.Error()}, nil, false)
+			return
+		}
+		st.writeFrame(protocol.StreamEnd, nil, nil, false)
+	}()
+}
+
+func (s *Server) lookupStream(req *protocol.Message) (StreamHandler, error) {
+	s.serviceMapMu.RLock()
+	defer s.serviceMapMu.RUnlock()
+
+	service := s.serviceMap[req.ServicePath]
+	if service == nil {
+		return nil, errors.New("phobos: can't find service " + req.ServicePath)
+	}
+	handler := service.stream[req.ServiceMethod]
+	if handler == nil {
+		return nil, errors.New("phobos: can't find stream method " + req.ServiceMethod)
+	}
+
+	return handler, nil
+}
+
+func callStreamHandler(handler StreamHandler, st *serverStream) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Errorf("phobos: stream handler panic: %v, stack:\n %s", r, buf)
+			err = fmt.Errorf("[service internal] error: %v", r)
+		}
+	}()
+
+	return handler(st.ctx, st)
+}
+
+func (s *Server) registerStream(conn net.Conn, seq uint64, st *serverStream) {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+
+	if s.streams == nil {
+		s.streams = make(map[net.Conn]map[uint64]*serverStream)
+	}
+	m := s.streams[conn]
+	if m == nil {
+		m = make(map[uint64]*serverStream)
+		s.streams[conn] = m
+	}
+	m[seq] = st
+}
+
+func (s *Server) unregisterStream(conn net.Conn, seq uint64) {
+	s.streamMu.Lock()
+	delete(s.streams[conn], seq)
+	s.streamMu.Unlock()
+}
+
+func (s *Server) getStream(conn net.Conn, seq uint64) *serverStream {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+
+	return s.streams[conn][seq]
+}
+
+// closeStreams ends all streams on conn after the reader goroutine of conn
+// exits, so handlers blocked in Recv or Send can return.
+func (s *Server) closeStreams(conn net.Conn) {
+	s.streamMu.Lock()
+	streams := s.streams[conn]
+	delete(s.streams, conn)
+	s.streamMu.Unlock()
+
+	for _, st := range streams {
+		st.closeRecv(errConnClosed)
+		st.cancel(errConnClosed)
+		st.window.Close()
+	}
+}
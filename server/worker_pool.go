@@ -0,0 +1,149 @@
+package server
+
+import (
+	"sync/atomic"
+
+	ex "github.com/marsevilspirit/phobos/errors"
+)
+
+// ErrServerOverloaded is returned to the client if the queue of the worker pool is full.
+var ErrServerOverloaded = ex.New(ex.ErrCodeRateLimitExceeded, "server overloaded")
+
+// workerPool runs the requests by at most size goroutines, the requests
+// waiting for a worker are queued up to the depth of the queue.
+// 没有空闲的常驻 worker, 队列为空时 worker 退出, 所以不需要关闭
+type workerPool struct {
+	workers chan struct{}
+	tasks   chan func()
+}
+
+func newWorkerPool(size, queue int) *workerPool {
+	if size <= 0 {
+		return nil
+	}
+	if queue < 0 {
+		queue = 0
+	}
+
+	return &workerPool{
+		workers: make(chan struct{}, size),
+		tasks:   make(chan func(), queue),
+	}
+}
+
+// submit runs task by a worker, it returns false if all the workers are busy
+// and the queue is full.
+func (p *workerPool) submit(task func()) bool {
+	select {
+	case p.workers <- struct{}{}:
+		go p.run(task)
+		return true
+	default:
+	}
+
+	select {
+	case p.tasks <- task:
+	default:
+		return false
+	}
+
+	// 入队时所有 worker 可能恰好都已退出
+	select {
+	case p.workers <- struct{}{}:
+		go p.run(nil)
+	default:
+	}
+
+	return true
+}
+
+func (p *workerPool) run(task func()) {
+	for {
+		if task != nil {
+			task()
+		}
+
+		select {
+		case task = <-p.tasks:
+			continue
+		default:
+		}
+
+		<-p.workers
+		// 释放之后再检查一次队列, 避免与 submit 竞争时留下没人处理的请求
+		if len(p.tasks) == 0 {
+			return
+		}
+		select {
+		case p.workers <- struct{}{}:
+			task = nil
+		default:
+			return
+		}
+	}
+}
+
+// connLimiter limits the requests and streams handled at the same time for a
+// connection, a nil connLimiter doesn't limit them.
+type connLimiter struct {
+	sem     chan struct{}
+	streams atomic.Int32
+}
+
+func newConnLimiter(n int) *connLimiter {
+	if n <= 0 {
+		return nil
+	}
+
+	return &connLimiter{sem: make(chan struct{}, n)}
+}
+
+// acquire waits for a slot for a request, the server stops reading from the
+// connection meanwhile. It returns false without waiting if streams hold slots,
+// they may need the frames behind the request to finish.
+func (l *connLimiter) acquire() bool {
+	if l == nil {
+		return true
+	}
+
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+	}
+	// 流只由读 goroutine 打开, 等待期间不会有新的流占用名额
+	if l.streams.Load() > 0 {
+		return false
+	}
+	l.sem <- struct{}{}
+	return true
+}
+
+func (l *connLimiter) release() {
+	if l != nil {
+		<-l.sem
+	}
+}
+
+// acquireStream reports whether a stream can be opened, it holds a slot until
+// releaseStream.
+func (l *connLimiter) acquireStream() bool {
+	if l == nil {
+		return true
+	}
+
+	select {
+	case l.sem <- struct{}{}:
+		l.streams.Add(1)
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *connLimiter) releaseStream() {
+	if l != nil {
+		l.streams.Add(-1)
+		<-l.sem
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marsevilspirit/phobos/protocol"
+)
+
+type Block struct {
+	running int32
+	release chan struct{}
+}
+
+func (t *Block) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	atomic.AddInt32(&t.running, 1)
+	<-t.release
+	reply.C = args.A * args.B
+	return nil
+}
+
+func writeMulRequest(t *testing.T, conn net.Conn, servicePath string, seq uint64) {
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(protocol.JSON)
+	req.SetSeq(seq)
+	req.ServicePath = servicePath
+	req.ServiceMethod = "Mul"
+	req.Payload, _ = json.Marshal(&Args{A: 10, B: 20})
+	if _, err := conn.Write(req.Encode()); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	p := newWorkerPool(2, 3)
+
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	release := make(chan struct{})
+	task := func() {
+		defer wg.Done()
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+	}
+
+	wg.Add(5)
+	for i := 0; i < 5; i++ {
+		if !p.submit(task) {
+			t.Fatalf("expect task %d to be accepted", i)
+		}
+	}
+	if p.submit(func() {}) {
+		t.Fatal("expect task to be rejected when the queue is full")
+	}
+
+	for atomic.LoadInt32(&running) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if maxRunning != 2 {
+		t.Fatalf("expect at most 2 running tasks, got %d", maxRunning)
+	}
+
+	// worker 全部退出后仍然可以提交
+	done := make(chan struct{})
+	if !p.submit(func() { close(done) }) {
+		t.Fatal("expect task to be accepted")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expect task to run")
+	}
+}
+
+func TestServerOverloaded(t *testing.T) {
+	block := &Block{release: make(chan struct{})}
+
+	s := NewServer(WithWorkerPool(1, 0))
+	s.RegisterWithName("Block", block, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	writeMulRequest(t, conn, "Block", 1)
+	time.Sleep(50 * time.Millisecond)
+	writeMulRequest(t, conn, "Block", 2)
+
+	res, err := protocol.Read(conn)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if res.Seq() != 2 || res.MessageStatusType() != protocol.Error {
+		t.Fatalf("expect request 2 to be rejected, got seq %d", res.Seq())
+	}
+	if e := res.Metadata[protocol.ServiceError]; e != ErrServerOverloaded.Error() {
+		t.Fatalf("expect %q but got %q", ErrServerOverloaded.Error(), e)
+	}
+
+	close(block.release)
+	res, err = protocol.Read(conn)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if res.Seq() != 1 || res.MessageStatusType() != protocol.Normal {
+		t.Fatalf("expect request 1 to succeed, got seq %d", res.Seq())
+	}
+}
+
+func TestMaxConcurrentRequestsPerConn(t *testing.T) {
+	block := &Block{release: make(chan struct{})}
+
+	s := NewServer(WithMaxConcurrentRequestsPerConn(1))
+	s.RegisterWithName("Block", block, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", s.Address().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	writeMulRequest(t, conn, "Block", 1)
+	writeMulRequest(t, conn, "Block", 2)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&block.running); n != 1 {
+		t.Fatalf("expect 1 running request, got %d", n)
+	}
+
+	close(block.release)
+	for i := 0; i < 2; i++ {
+		res, err := protocol.Read(conn)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		if res.MessageStatusType() != protocol.Normal {
+			t.Fatalf("expect request %d to succeed", res.Seq())
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package serverplugin
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	ex "github.com/marsevilspirit/phobos/errors"
+	"github.com/marsevilspirit/phobos/protocol"
+	"github.com/marsevilspirit/phobos/server"
+	"github.com/marsevilspirit/phobos/share"
+)
+
+var (
+	// ErrTooManyConcurrentRequests is returned if the max concurrent executions are reached.
+	ErrTooManyConcurrentRequests = ex.New(ex.ErrCodeRateLimitExceeded, "too many concurrent requests")
+	// ErrRateLimited is returned if the rate limit is exceeded.
+	ErrRateLimited = ex.New(ex.ErrCodeRateLimitExceeded, "rate limit exceeded")
+)
+
+// clientLimiterIdle is how long the limiter of a client is kept after its last request.
+const clientLimiterIdle = time.Minute
+
+// Limit is the limit of the requests. The zero value means no limit.
+type Limit struct {
+	// MaxConcurrent is the max number of requests executing at the same time.
+	MaxConcurrent int
+	// Rate is the number of requests allowed per second.
+	Rate float64
+	// Burst is the max number of requests allowed at once, at least 1 if Rate is set.
+	Burst int
+}
+
+// limiter is a concurrency limit and a token bucket.
+type limiter struct {
+	limit Limit
+
+	mu       sync.Mutex
+	inflight int
+	tokens   float64
+	last     time.Time
+}
+
+func newLimiter(l Limit) *limiter {
+	if l.Rate > 0 && l.Burst < 1 {
+		l.Burst = 1
+	}
+
+	return &limiter{limit: l, tokens: float64(l.Burst), last: time.Now()}
+}
+
+func (l *limiter) acquire(now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit.MaxConcurrent > 0 && l.inflight >= l.limit.MaxConcurrent {
+		return ErrTooManyConcurrentRequests
+	}
+
+	if l.limit.Rate > 0 {
+		// 限流器可能在 now 之后才创建
+		if now.After(l.last) {
+			l.tokens += now.Sub(l.last).Seconds() * l.limit.Rate
+			if l.tokens > float64(l.limit.Burst) {
+				l.tokens = float64(l.limit.Burst)
+			}
+			l.last = now
+		}
+		if l.tokens < 1 {
+			return ErrRateLimited
+		}
+		l.tokens--
+	}
+
+	l.inflight++
+	return nil
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	l.inflight--
+	l.mu.Unlock()
+}
+
+// cancel releases the limiter and returns the token of a request rejected by another limiter.
+func (l *limiter) cancel() {
+	l.mu.Lock()
+	l.inflight--
+	if l.limit.Rate > 0 && l.tokens < float64(l.limit.Burst) {
+		l.tokens++
+	}
+	l.mu.Unlock()
+}
+
+// idle reports whether the limiter is in its initial state again.
+func (l *limiter) idle(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.inflight == 0 && now.Sub(l.last) >= clientLimiterIdle
+}
+
+// LimitPlugin limits the concurrent executions and the rate of the requests
+// per service, per method and per client. The rejected requests fail with
+// ErrTooManyConcurrentRequests or ErrRateLimited, whose code is
+// errors.ErrCodeRateLimitExceeded.
+type LimitPlugin struct {
+	// ClientKey returns the identity of the client of a request, which is the
+	// share.AuthKey token, or the IP of the remote address by default.
+	ClientKey func(ctx context.Context, r *protocol.Message) string
+
+	mu      sync.RWMutex
+	methods map[string]*limiter
+	client  *Limit
+
+	clientsMu sync.Mutex
+	clients   map[string]*limiter
+	lastPrune time.Time
+
+	// 每个请求获取到的限流器, 处理完之后释放
+	acquired sync.Map
+}
+
+// NewLimitPlugin creates a LimitPlugin without limits.
+func NewLimitPlugin() *LimitPlugin {
+	return &LimitPlugin{
+		methods: make(map[string]*limiter),
+		clients: make(map[string]*limiter),
+	}
+}
+
+// SetServiceLimit sets the limit of all the methods of servicePath together.
+func (p *LimitPlugin) SetServiceLimit(servicePath string, l Limit) *LimitPlugin {
+	p.mu.Lock()
+	p.methods[servicePath] = newLimiter(l)
+	p.mu.Unlock()
+	return p
+}
+
+// SetMethodLimit sets the limit of servicePath.serviceMethod, it applies in
+// addition to the limit of the service.
+func (p *LimitPlugin) SetMethodLimit(servicePath, serviceMethod string, l Limit) *LimitPlugin {
+	p.mu.Lock()
+	p.methods[servicePath+"."+serviceMethod] = newLimiter(l)
+	p.mu.Unlock()
+	return p
+}
+
+// SetClientLimit sets the limit of the requests of each client.
+func (p *LimitPlugin) SetClientLimit(l Limit) *LimitPlugin {
+	p.mu.Lock()
+	p.client = &l
+	p.mu.Unlock()
+
+	p.clientsMu.Lock()
+	p.clients = make(map[string]*limiter)
+	p.clientsMu.Unlock()
+	return p
+}
+
+func (p *LimitPlugin) clientKey(ctx context.Context, r *protocol.Message) string {
+	if p.ClientKey != nil {
+		return p.ClientKey(ctx, r)
+	}
+
+	if token := r.Metadata[share.AuthKey]; token != "" {
+		return token
+	}
+	if conn, ok := ctx.Value(server.RemoteConnContextKey).(net.Conn); ok {
+		addr := conn.RemoteAddr().String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			return host
+		}
+		return addr
+	}
+
+	return ""
+}
+
+func (p *LimitPlugin) clientLimiter(key string, l Limit, now time.Time) *limiter {
+	p.clientsMu.Lock()
+	defer p.clientsMu.Unlock()
+
+	// 定期清理空闲的客户端, 避免 map 无限增长
+	if now.Sub(p.lastPrune) >= clientLimiterIdle {
+		p.lastPrune = now
+		for k, cl := range p.clients {
+			if cl.idle(now) {
+				delete(p.clients, k)
+			}
+		}
+	}
+
+	cl := p.clients[key]
+	if cl == nil {
+		cl = newLimiter(l)
+		p.clients[key] = cl
+	}
+
+	return cl
+}
+
+// PreHandleRequest implements server.PreHandleRequestPlugin.
+func (p *LimitPlugin) PreHandleRequest(ctx context.Context, r *protocol.Message) error {
+	now := time.Now()
+
+	p.mu.RLock()
+	service := p.methods[r.ServicePath]
+	method := p.methods[r.ServicePath+"."+r.ServiceMethod]
+	client := p.client
+	p.mu.RUnlock()
+
+	// 先检查客户端的限制, 一个客户端超限时不消耗服务和方法的令牌
+	limiters := make([]*limiter, 0, 3)
+	if client != nil {
+		limiters = append(limiters, p.clientLimiter(p.clientKey(ctx, r), *client, now))
+	}
+	if service != nil {
+		limiters = append(limiters, service)
+	}
+	if method != nil {
+		limiters = append(limiters, method)
+	}
+	if len(limiters) == 0 {
+		return nil
+	}
+
+	for i, l := range limiters {
+		if err := l.acquire(now); err != nil {
+			for _, l := range limiters[:i] {
+				l.cancel()
+			}
+			return err
+		}
+	}
+	p.acquired.Store(r, limiters)
+
+	return nil
+}
+
+// PostHandleRequest implements server.PostHandleRequestPlugin.
+func (p *LimitPlugin) PostHandleRequest(ctx context.Context, r *protocol.Message, res *protocol.Message, e error) {
+	v, ok := p.acquired.LoadAndDelete(r)
+	if !ok {
+		return
+	}
+	for _, l := range v.([]*limiter) {
+		l.release()
+	}
+}
@@ -0,0 +1,107 @@
+package serverplugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/marsevilspirit/phobos/protocol"
+	"github.com/marsevilspirit/phobos/share"
+)
+
+func newLimitRequest(servicePath, serviceMethod, token string) *protocol.Message {
+	req := protocol.NewMessage()
+	req.ServicePath = servicePath
+	req.ServiceMethod = serviceMethod
+	if token != "" {
+		req.Metadata[share.AuthKey] = token
+	}
+	return req
+}
+
+func TestLimitPlugin_Concurrent(t *testing.T) {
+	p := NewLimitPlugin().
+		SetServiceLimit("Arith", Limit{MaxConcurrent: 2}).
+		SetMethodLimit("Arith", "Mul", Limit{MaxConcurrent: 1})
+	ctx := context.Background()
+
+	mul := newLimitRequest("Arith", "Mul", "")
+	if err := p.PreHandleRequest(ctx, mul); err != nil {
+		t.Fatalf("expect request to be allowed: %v", err)
+	}
+	if err := p.PreHandleRequest(ctx, newLimitRequest("Arith", "Mul", "")); err != ErrTooManyConcurrentRequests {
+		t.Fatalf("expect method limit, got %v", err)
+	}
+
+	add := newLimitRequest("Arith", "Add", "")
+	if err := p.PreHandleRequest(ctx, add); err != nil {
+		t.Fatalf("expect request to be allowed: %v", err)
+	}
+	if err := p.PreHandleRequest(ctx, newLimitRequest("Arith", "Add", "")); err != ErrTooManyConcurrentRequests {
+		t.Fatalf("expect service limit, got %v", err)
+	}
+
+	// 被拒绝的请求不占用服务的并发数
+	p.PostHandleRequest(ctx, mul, nil, nil)
+	if err := p.PreHandleRequest(ctx, newLimitRequest("Arith", "Mul", "")); err != nil {
+		t.Fatalf("expect request to be allowed after release: %v", err)
+	}
+	p.PostHandleRequest(ctx, add, nil, nil)
+
+	if err := p.PreHandleRequest(ctx, newLimitRequest("Echo", "Say", "")); err != nil {
+		t.Fatalf("expect other services not to be limited: %v", err)
+	}
+}
+
+func TestLimitPlugin_Rate(t *testing.T) {
+	p := NewLimitPlugin().SetClientLimit(Limit{Rate: 20, Burst: 2})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		req := newLimitRequest("Arith", "Mul", "alice")
+		if err := p.PreHandleRequest(ctx, req); err != nil {
+			t.Fatalf("expect request %d within burst to be allowed: %v", i, err)
+		}
+		p.PostHandleRequest(ctx, req, nil, nil)
+	}
+	if err := p.PreHandleRequest(ctx, newLimitRequest("Arith", "Mul", "alice")); err != ErrRateLimited {
+		t.Fatalf("expect rate limit, got %v", err)
+	}
+	if err := p.PreHandleRequest(ctx, newLimitRequest("Arith", "Mul", "bob")); err != nil {
+		t.Fatalf("expect other clients not to be limited: %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := p.PreHandleRequest(ctx, newLimitRequest("Arith", "Mul", "alice")); err != nil {
+		t.Fatalf("expect request to be allowed after refill: %v", err)
+	}
+}
+
+func TestLimitPlugin_RejectedKeepsTokens(t *testing.T) {
+	p := NewLimitPlugin().
+		SetServiceLimit("Arith", Limit{Rate: 0.001, Burst: 2}).
+		SetMethodLimit("Arith", "Mul", Limit{MaxConcurrent: 1}).
+		SetClientLimit(Limit{Rate: 0.001, Burst: 1})
+	ctx := context.Background()
+
+	alice := newLimitRequest("Arith", "Mul", "alice")
+	if err := p.PreHandleRequest(ctx, alice); err != nil {
+		t.Fatalf("expect the first request to be allowed: %v", err)
+	}
+
+	// 超过客户端限制的请求不消耗服务的令牌
+	for i := 0; i < 3; i++ {
+		if err := p.PreHandleRequest(ctx, newLimitRequest("Arith", "Mul", "alice")); err != ErrRateLimited {
+			t.Fatalf("expect the client to be rate limited, got %v", err)
+		}
+	}
+	// 被方法的并发限制拒绝的请求归还服务和客户端的令牌
+	if err := p.PreHandleRequest(ctx, newLimitRequest("Arith", "Mul", "bob")); err != ErrTooManyConcurrentRequests {
+		t.Fatalf("expect the method to be limited, got %v", err)
+	}
+	p.PostHandleRequest(ctx, alice, nil, nil)
+
+	if err := p.PreHandleRequest(ctx, newLimitRequest("Arith", "Mul", "bob")); err != nil {
+		t.Fatalf("expect the tokens of the rejected requests to be kept: %v", err)
+	}
+}
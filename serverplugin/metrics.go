@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/marsevilspirit/phobos/protocol"
+	"github.com/marsevilspirit/phobos/server"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -90,3 +91,38 @@ func (mp *MetricsPlugin) PostReadRequest(ctx context.Context, r *protocol.Messag
 // 	fmt.Println("metrics Post-writing response")
 // 	return nil
 // }
+
+// AdaptiveLimitCollector 导出服务端自适应并发限制的当前值
+type AdaptiveLimitCollector struct {
+	s        *server.Server
+	limit    *prometheus.Desc
+	inflight *prometheus.Desc
+}
+
+// NewAdaptiveLimitCollector 创建 s 的 AdaptiveLimitCollector, 需要调用方注册到 prometheus.
+// service 标签为空时表示整个服务端默认的限制
+func NewAdaptiveLimitCollector(s *server.Server) *AdaptiveLimitCollector {
+	return &AdaptiveLimitCollector{
+		s: s,
+		limit: prometheus.NewDesc("adaptive_concurrency_limit",
+			"Current limit of in-flight requests of the adaptive concurrency limiter.",
+			[]string{"service"}, nil),
+		inflight: prometheus.NewDesc("adaptive_concurrency_inflight",
+			"In-flight requests counted by the adaptive concurrency limiter.",
+			[]string{"service"}, nil),
+	}
+}
+
+// Describe 实现 prometheus.Collector 接口
+func (c *AdaptiveLimitCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.limit
+	ch <- c.inflight
+}
+
+// Collect 实现 prometheus.Collector 接口
+func (c *AdaptiveLimitCollector) Collect(ch chan<- prometheus.Metric) {
+	for service, st := range c.s.AdaptiveLimits() {
+		ch <- prometheus.MustNewConstMetric(c.limit, prometheus.GaugeValue, float64(st.Limit), service)
+		ch <- prometheus.MustNewConstMetric(c.inflight, prometheus.GaugeValue, float64(st.Inflight), service)
+	}
+}
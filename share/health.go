@@ -0,0 +1,48 @@
+package share
+
+const (
+	// HealthServicePath is the service path of the built-in health service of the server.
+	HealthServicePath = "__phobos_health__"
+
+	// HealthCheck returns the serving status of a service,
+	// args is *HealthCheckArgs and reply is *HealthCheckReply.
+	HealthCheck = "Check"
+)
+
+// ServingStatus is the serving status of a server or a service.
+type ServingStatus int
+
+const (
+	ServingStatusUnknown ServingStatus = iota
+	// Serving means the service accepts new requests.
+	Serving
+	// NotServing means the service does not accept requests.
+	NotServing
+	// Draining means the service is finishing in-flight requests and
+	// clients should stop sending new ones.
+	Draining
+)
+
+func (s ServingStatus) String() string {
+	switch s {
+	case Serving:
+		return "SERVING"
+	case NotServing:
+		return "NOT_SERVING"
+	case Draining:
+		return "DRAINING"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// HealthCheckArgs is the args of HealthCheck.
+type HealthCheckArgs struct {
+	// ServicePath is the service to check, empty means the whole server.
+	ServicePath string `json:"servicePath,omitempty"`
+}
+
+// HealthCheckReply is the reply of HealthCheck.
+type HealthCheckReply struct {
+	Status ServingStatus `json:"status"`
+}
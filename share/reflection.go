@@ -0,0 +1,61 @@
+package share
+
+const (
+	// ReflectionServicePath is the service path of the built-in reflection
+	// service of the server. Clients can call it like any other service.
+	ReflectionServicePath = "__phobos_reflection__"
+
+	// ReflectionListServices lists the registered services,
+	// args is *ListServicesArgs and reply is *ListServicesReply.
+	ReflectionListServices = "ListServices"
+)
+
+const (
+	MethodKindUnary  = "unary"
+	MethodKindStream = "stream"
+)
+
+// ListServicesArgs is the args of ReflectionListServices.
+type ListServicesArgs struct {
+	// ServicePath only lists the given service if it is not empty.
+	ServicePath string `json:"servicePath,omitempty"`
+}
+
+// ListServicesReply is the reply of ReflectionListServices.
+type ListServicesReply struct {
+	Services []*ServiceInfo `json:"services"`
+}
+
+// ServiceInfo describes a registered service.
+type ServiceInfo struct {
+	Name string `json:"name"`
+	// Metadata is the metadata passed when the service was registered.
+	Metadata string        `json:"metadata,omitempty"`
+	Methods  []*MethodInfo `json:"methods"`
+}
+
+// MethodInfo describes a method of a service.
+type MethodInfo struct {
+	Name string `json:"name"`
+	// Kind is MethodKindUnary or MethodKindStream.
+	Kind string `json:"kind"`
+	// ArgType and ReplyType are nil for stream methods, their message types
+	// are not known by the server.
+	ArgType   *TypeSchema `json:"argType,omitempty"`
+	ReplyType *TypeSchema `json:"replyType,omitempty"`
+}
+
+// TypeSchema is a JSON schema like description of a Go type.
+type TypeSchema struct {
+	// Name is the Go type name, such as "main.Args". It is empty for unnamed types.
+	Name string `json:"name,omitempty"`
+	// Type is one of "object", "array", "string", "integer", "number", "boolean" and "any".
+	Type   string `json:"type"`
+	Format string `json:"format,omitempty"`
+	// Ref refers to the enclosing type with the same Name, it is set for recursive types.
+	Ref string `json:"$ref,omitempty"`
+
+	Properties           map[string]*TypeSchema `json:"properties,omitempty"`
+	Items                *TypeSchema            `json:"items,omitempty"`
+	AdditionalProperties *TypeSchema            `json:"additionalProperties,omitempty"`
+}
@@ -11,6 +11,14 @@ const (
 
 	// used by auth
 	AuthKey = "__AUTH"
+
+	// TimeoutKey carries the remaining time budget of the caller in
+	// milliseconds, so the server can stop working once the caller is gone.
+	TimeoutKey = "__TIMEOUT"
+
+	// HashKey in the request metadata is the key the ConsistentHash selector
+	// of the client selects the server by.
+	HashKey = "__HASH_KEY"
 )
 
 var (